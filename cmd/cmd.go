@@ -0,0 +1,243 @@
+// Package cmd holds the commands of the server: serve, which runs the API,
+// and the administrative commands operators run against its databases
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"server/config"
+	"server/database"
+	"server/models"
+	"server/security"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+// COMMANDS are looked up by their name, which is one or two words
+var COMMANDS = []command{
+	{"serve", "", "Run the API server, the default without a command", serve},
+	{"migrate up", "[-dry-run]", "Apply the pending migrations", migrateUp},
+	{"migrate down", "[-dry-run] [-store mongo|sql] [-steps N]", "Revert the last migrations", migrateDown},
+	{"migrate status", "", "List the migrations and whether they are applied", migrateStatus},
+	{"user create", "[-name NAME] [-email EMAIL] [-title TITLE] [-birthdate YYYY-MM-DD] [-role ROLE]... [-password-file PATH] [-must-change-password]", "Create a user with a password", createUser},
+	{"user list", "[-limit N] [-cursor CURSOR] [-email EMAIL] [-role ROLE] [-deleted]", "List users", listUsers},
+	{"user reset-password", "[-password-file PATH] EMAIL|ID", "Set a new password, to be changed on login, and lift any lockout", resetPassword},
+	{"user promote", "[-role ROLE] EMAIL|ID", "Give a role to a user, admin by default", promoteUser},
+	{"user demote", "[-role ROLE] EMAIL|ID", "Take a role away from a user, admin by default", demoteUser},
+	{"token issue", "EMAIL|ID", "Log a user in and print their access token", issueToken},
+}
+
+// Every command but serve accepts these flags
+const COMMON_USAGE = "Flags of every command but serve: -json prints results as JSON, " +
+	"-non-interactive never asks anything, which is the default when stdin is no terminal."
+
+var (
+	// out receives the results of the commands, os.Stdout being pointed
+	// at stderr while they run so that scripts only read results there
+	out io.Writer = os.Stdout
+
+	stdin = bufio.NewReader(os.Stdin)
+)
+
+// options are the flags every command but serve accepts
+type options struct {
+	json           bool
+	nonInteractive bool
+}
+
+// Run runs the command args name, followed by its arguments,
+// serving the API when there is none
+func Run(args []string) error {
+	if err := config.Check(); err != nil {
+		return fmt.Errorf("reading config/config.yml: %w", err)
+	}
+
+	if len(args) == 0 {
+		return serve(args)
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage()
+		return nil
+	}
+
+	for _, words := range []int{2, 1} {
+		if len(args) < words {
+			continue
+		}
+
+		name := strings.Join(args[:words], " ")
+		for _, cmd := range COMMANDS {
+			if cmd.name != name {
+				continue
+			}
+
+			if name != "serve" {
+				stdout := os.Stdout
+				os.Stdout = os.Stderr
+				defer func() { os.Stdout = stdout }()
+			}
+
+			err := cmd.run(args[words:])
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	printUsage()
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: server [command] [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range COMMANDS {
+		fmt.Fprintf(os.Stderr, "  %s\n      %s\n", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.description)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, COMMON_USAGE)
+}
+
+// newFlagSet returns the flags of the command name,
+// along with the options every command accepts
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	opts := &options{}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.BoolVar(&opts.json, "json", false, "print results as JSON")
+	flags.BoolVar(&opts.nonInteractive, "non-interactive", false, "never ask anything, failing on missing values instead")
+
+	return flags, opts
+}
+
+// interactive tells whether the command may ask the operator for
+// what its flags are missing, who has to be at a terminal for it
+func (opts *options) interactive() bool {
+	if opts.nonInteractive {
+		return false
+	}
+
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ask reads value from the operator when it is empty and they can
+// be asked, leaving it empty otherwise for validation to refuse
+func (opts *options) ask(label string, value *string) error {
+	if *value != "" || !opts.interactive() {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	*value = strings.TrimSpace(line)
+	return nil
+}
+
+// confirm asks the operator to confirm question, which
+// non-interactive commands take as confirmed
+func (opts *options) confirm(question string) error {
+	if !opts.interactive() {
+		return nil
+	}
+
+	answer := ""
+	if err := opts.ask(question+" [y/N]", &answer); err != nil {
+		return err
+	}
+
+	if answer != "y" && answer != "Y" && answer != "yes" {
+		return errors.New("cancelled")
+	}
+
+	return nil
+}
+
+// print writes result as JSON when asked to, and as text otherwise
+func (opts *options) print(result interface{}, text string) error {
+	if !opts.json {
+		_, err := fmt.Fprintln(out, text)
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// onlyArgument returns the single argument of a command, such as
+// the email or ID of the user it acts on
+func onlyArgument(flags *flag.FlagSet, name string) (string, error) {
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("usage: %s [flags] EMAIL|ID", name)
+	}
+
+	return flags.Arg(0), nil
+}
+
+// findUser returns the user whose ID or email is ref
+func findUser(client *database.UsersClient, ref string) (models.User, error) {
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		user, getError := database.GetById(client.Ctx, client.Users, id)
+		return user, errorOf(getError)
+	}
+
+	user, err := database.GetByEmail(client.Ctx, client.Users, ref)
+	return user, errorOf(err)
+}
+
+// readPassword reads the password in path, from stdin when path is
+// "-", generating one when there is no path. Generated passwords
+// are printed once, to stderr, and never shown again.
+func readPassword(path string) (string, error) {
+	if path == "" {
+		password, err := security.NewRandomPassword()
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintln(os.Stderr, "Generated password, shown only once:", password)
+		return password, nil
+	}
+
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// errorOf turns the errors of the database functions into Go errors
+func errorOf(err fiber.Error) error {
+	if (fiber.Error{}) == err {
+		return nil
+	}
+
+	return errors.New(err.Message)
+}
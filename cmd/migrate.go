@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"server/database"
+	"strings"
+)
+
+func migrateUp(args []string) error {
+	flags, opts := newFlagSet("migrate up")
+	dryRun := flags.Bool("dry-run", false, "list the migrations to apply without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+	migrations, err := database.MigrateUp(client, *dryRun)
+
+	state := "applied"
+	if *dryRun {
+		state = "would be applied"
+	}
+
+	return printMigrations(opts, migrations, state, err)
+}
+
+func migrateDown(args []string) error {
+	flags, opts := newFlagSet("migrate down")
+	dryRun := flags.Bool("dry-run", false, "list the migrations to revert without reverting them")
+	store := flags.String("store", database.MIGRATION_STORE_MONGO, "store to migrate down: mongo or sql")
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+	if !*dryRun {
+		if err := opts.confirm(fmt.Sprintf("Revert the last %d %s migrations?", *steps, *store)); err != nil {
+			return err
+		}
+	}
+
+	migrations, err := database.MigrateDown(client, *store, *steps, *dryRun)
+
+	state := "reverted"
+	if *dryRun {
+		state = "would be reverted"
+	}
+
+	return printMigrations(opts, migrations, state, err)
+}
+
+func migrateStatus(args []string) error {
+	flags, opts := newFlagSet("migrate status")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+	migrations, err := database.GetMigrationStatus(client)
+
+	return printMigrations(opts, migrations, "", err)
+}
+
+// printMigrations prints migrations, in state unless state is empty, in
+// which case they are pending or applied, and then returns err. Migrations
+// run before an error are printed too.
+func printMigrations(opts *options, migrations []database.MigrationStatus, state string, err error) error {
+	if len(migrations) == 0 && !opts.json {
+		fmt.Fprintln(os.Stderr, "No migrations", state)
+		return err
+	}
+
+	lines := []string{}
+	for _, migration := range migrations {
+		migrationState := state
+		if migrationState == "" {
+			migrationState = "pending"
+			if migration.AppliedAt != nil {
+				migrationState = "applied " + migration.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("%-6s %4d  %-30s %s", migration.Store, migration.Version, migration.Name, migrationState))
+	}
+
+	if printError := opts.print(migrations, strings.Join(lines, "\n")); printError != nil && err == nil {
+		err = printError
+	}
+
+	return err
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"server/config"
+	"server/database"
+	"server/handlers"
+	"server/middleware"
+	"server/ratelimit"
+	"server/routes"
+	"server/security"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+)
+
+func setupRoutes(app *fiber.App) {
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "You are at the root endpoint"})
+	})
+
+	app.Get("/.well-known/jwks.json", handlers.JwksHandler)
+
+	api := app.Group("/api")
+
+	routes.UsersRoute(api.Group("/users"))
+	routes.RolesRoute(api.Group("/roles"))
+	routes.AuditRoute(api.Group("/audit"))
+	routes.PasswordRoute(api.Group("/password"))
+	routes.InvitationsRoute(api.Group("/invitations"))
+}
+
+// serve runs the API server until it fails
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := security.CheckBreachedPasswords(); err != nil {
+		return errors.New("Error reading breached passwords: " + err.Error())
+	}
+
+	client := database.SetupDatabaseClient()
+	if err := database.BootstrapAdmin(client, config.GetConfig().Bootstrap); err != nil {
+		return err
+	}
+
+	database.StartSigningKeyRotation(client)
+	database.StartDeletedUserPurge(client)
+	database.StartPasswordExpiryReminders(client)
+
+	proxy := config.GetConfig().Proxy
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             proxy.Header,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          proxy.TrustedProxies,
+	})
+
+	// A panicking request fails alone, instead of the whole server
+	app.Use(recover.New())
+	app.Use(cors.New())
+	app.Use(logger.New())
+	app.Use(middleware.AddDatabaseClientToContext(client))
+
+	if conf := config.GetConfig().RateLimit; conf.Enabled {
+		limiter, err := ratelimit.New(conf, client.DB)
+		if err != nil {
+			return errors.New("Error setting up rate limiting: " + err.Error())
+		}
+		app.Use(middleware.RateLimit(limiter))
+	}
+
+	setupRoutes(app)
+
+	return app.Listen(":" + config.GetConfig().Port)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"server/database"
+	"server/security"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// issueToken prints an access token of a user, opening a session
+// for them, along with its refresh token when printing JSON
+func issueToken(args []string) error {
+	flags, opts := newFlagSet("token issue")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ref, err := onlyArgument(flags, "token issue")
+	if err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+
+	// The keys are the server's, which rotates them
+	if err := database.LoadSigningKeys(client); err != nil {
+		return err
+	}
+	if len(security.JWKS().Keys) == 0 {
+		return errors.New("no signing keys yet, start the server first")
+	}
+
+	user, err := findUser(client, ref)
+	if err != nil {
+		return err
+	}
+
+	id, _ := primitive.ObjectIDFromHex(user.ID)
+	result, issueError := database.IssueToken(client, database.CommandLineActor, id)
+	if err := errorOf(issueError); err != nil {
+		return err
+	}
+
+	// The token would only let them change their password
+	if result.PasswordChangeRequired {
+		return errors.New("the user has to change their password first")
+	}
+
+	return opts.print(result, result.Token)
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"server/database"
+	"server/models"
+	"server/security"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+func createUser(args []string) error {
+	flags, opts := newFlagSet("user create")
+	user := models.CreateWithPasswordArgs{}
+	roles := stringList{}
+	passwordFile := ""
+	flags.StringVar(&user.Name, "name", "", "name of the user")
+	flags.StringVar(&user.Email, "email", "", "email of the user")
+	flags.StringVar(&user.Title, "title", "", "title of the user")
+	flags.StringVar(&user.Birthdate, "birthdate", "", "birthdate of the user, as YYYY-MM-DD")
+	flags.Var(&roles, "role", "role of the user, which can be given several times")
+	flags.StringVar(&passwordFile, "password-file", "", "file holding the password, - for stdin, a password being generated without one")
+	flags.BoolVar(&user.MustChangePassword, "must-change-password", false, "make the user change their password when they log in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	questions := []struct {
+		label string
+		value *string
+	}{
+		{"Name", &user.Name},
+		{"Email", &user.Email},
+		{"Title", &user.Title},
+		{"Birthdate (YYYY-MM-DD)", &user.Birthdate},
+	}
+	for _, question := range questions {
+		if err := opts.ask(question.label, question.value); err != nil {
+			return err
+		}
+	}
+	user.Roles = roles
+
+	client := database.ConnectDatabaseClient()
+
+	password, err := readPassword(passwordFile)
+	if err != nil {
+		return err
+	}
+	user.Password = password
+
+	created, createError := database.CreateWithPassword(client, database.CommandLineActor, user)
+	if err := errorOf(createError); err != nil {
+		return err
+	}
+
+	return opts.print(created, "Created user "+created.ID)
+}
+
+func listUsers(args []string) error {
+	flags, opts := newFlagSet("user list")
+	query := models.GetAllArgs{}
+	deleted := false
+	flags.IntVar(&query.Limit, "limit", database.DEFAULT_PAGE_SIZE, "number of users to list")
+	flags.StringVar(&query.Cursor, "cursor", "", "cursor of the page to list, printed after the previous one")
+	flags.StringVar(&query.Email, "email", "", "only list users whose email contains this")
+	flags.StringVar(&query.Role, "role", "", "only list users holding this role")
+	flags.BoolVar(&deleted, "deleted", false, "list soft deleted users instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+
+	list := database.GetAll
+	if deleted {
+		list = database.GetDeleted
+	}
+
+	page, listError := list(client.Ctx, client.Users, query)
+	if err := errorOf(listError); err != nil {
+		return err
+	}
+
+	lines := []string{}
+	for _, user := range page.Users {
+		lines = append(lines, fmt.Sprintf("%s  %-30s %-25s %s", user.ID, user.Email, user.Name, strings.Join(user.Roles, ",")))
+	}
+	lines = append(lines, fmt.Sprintf("%d users", page.Total))
+	if page.NextCursor != "" {
+		lines = append(lines, "Next page: -cursor "+page.NextCursor)
+	}
+
+	return opts.print(page, strings.Join(lines, "\n"))
+}
+
+// resetPassword lets operators back into an account, such as the one
+// of an admin who forgot their password or got locked out
+func resetPassword(args []string) error {
+	flags, opts := newFlagSet("user reset-password")
+	passwordFile := ""
+	flags.StringVar(&passwordFile, "password-file", "", "file holding the password, - for stdin, a password being generated without one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ref, err := onlyArgument(flags, "user reset-password")
+	if err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+
+	user, err := findUser(client, ref)
+	if err != nil {
+		return err
+	}
+
+	if err := opts.confirm("Reset the password of " + user.Email + "?"); err != nil {
+		return err
+	}
+
+	password, err := readPassword(passwordFile)
+	if err != nil {
+		return err
+	}
+
+	id, _ := primitive.ObjectIDFromHex(user.ID)
+	changeError := database.ChangePassword(client, database.CommandLineActor, id, models.ChangePasswordArgs{Password: password})
+	if err := errorOf(changeError); err != nil {
+		return err
+	}
+
+	unlocked, unlockError := database.UnlockUser(client, database.CommandLineActor, id)
+	if err := errorOf(unlockError); err != nil {
+		return err
+	}
+
+	return opts.print(unlocked, "Reset the password of "+unlocked.Email+", to be changed on login")
+}
+
+func promoteUser(args []string) error {
+	return changeUserRole(args, "user promote", database.GrantRole, "Gave the %s role to %s")
+}
+
+func demoteUser(args []string) error {
+	return changeUserRole(args, "user demote", database.RevokeRole, "Took the %s role away from %s")
+}
+
+// changeUserRole runs the command name, which gives or takes a role
+// away through change, reporting it with message
+func changeUserRole(args []string, name string, change func(*database.UsersClient, models.Actor, primitive.ObjectID, string) (models.User, fiber.Error), message string) error {
+	flags, opts := newFlagSet(name)
+	role := flags.String("role", security.ROLE_ADMIN, "role to give or take away")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ref, err := onlyArgument(flags, name)
+	if err != nil {
+		return err
+	}
+
+	client := database.ConnectDatabaseClient()
+
+	user, err := findUser(client, ref)
+	if err != nil {
+		return err
+	}
+
+	id, _ := primitive.ObjectIDFromHex(user.ID)
+	changed, changeError := change(client, database.CommandLineActor, id, *role)
+	if err := errorOf(changeError); err != nil {
+		return err
+	}
+
+	return opts.print(changed, fmt.Sprintf(message, *role, changed.Email))
+}
@@ -1,38 +1,337 @@
 package config
 
 import (
+	"sync"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Configuration struct {
 	Environment string
-	Token       string
 	Port        string
+	Proxy       ProxyConfiguration
+	Auth        AuthConfiguration
+	Jwt         JwtConfiguration
+	Users       UsersConfiguration
+	Password    PasswordConfiguration
+	Mail        MailConfiguration
+	Invitation  InvitationConfiguration
+	Email       EmailConfiguration
+	Lockout     LockoutConfiguration
+	RateLimit   RateLimitConfiguration
+	Migrations  MigrationsConfiguration
+	Bootstrap   BootstrapConfiguration
 	Mongo       MongoConfiguration
 }
 
+// ProxyConfiguration tells where the IP of clients comes from, which
+// login throttling, rate limiting and the audit log go by. Requests
+// coming from one of TrustedProxies, which are IP addresses, are taken
+// to be from the IP in their Header, such as X-Forwarded-For, the last
+// one when it lists several. Other requests, and every request when
+// Header is empty, are taken to be from the address they come from.
+type ProxyConfiguration struct {
+	Header         string
+	TrustedProxies []string
+}
+
+type AuthConfiguration struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	MfaTokenTTL     time.Duration
+	MfaIssuer       string
+
+	// Lifetime of the restricted tokens given to
+	// users who have to change their password
+	PasswordChangeTokenTTL time.Duration
+
+	// Whether users have to verify their email address before logging in
+	RequireVerifiedEmail bool
+}
+
+// JwtConfiguration describes how access tokens are signed.
+// Algorithm is either RS256 or ES256. A new signing key is generated
+// every RotationInterval, and the keys shared by all replicas are
+// reloaded every RefreshInterval. A new key only signs tokens once
+// RefreshInterval has passed, by when every replica knows it.
+type JwtConfiguration struct {
+	Algorithm        string
+	RotationInterval time.Duration
+	RefreshInterval  time.Duration
+}
+
+// UsersConfiguration sets where users are stored, how long soft
+// deleted users are kept before being purged, and how often the purge runs
+type UsersConfiguration struct {
+	Storage          StorageConfiguration
+	DeletedRetention time.Duration
+	PurgeInterval    time.Duration
+}
+
+// LockoutConfiguration sets how failed logins are throttled. After each
+// failure in a row, the next attempt has to wait DelayStep, doubled at
+// every failure up to MaxDelay. MaxAccountFailures failures on an account,
+// or MaxIPFailures from an IP, lock them out for Duration. Failures older
+// than Window are forgotten. Wrong second factors count as failures of the
+// account too, and a pending two-factor login is abandoned after
+// MaxMfaTokenFailures of them.
+type LockoutConfiguration struct {
+	MaxAccountFailures  int
+	MaxIPFailures       int
+	MaxMfaTokenFailures int
+	Duration            time.Duration
+	Window              time.Duration
+	DelayStep           time.Duration
+	MaxDelay            time.Duration
+}
+
+// RateLimitConfiguration lists the rate limiting policies. A request is
+// limited by the first policy matching its method and path, if any.
+// Backend is either memory, where every replica has its own limits,
+// or mongo, where they share them. ApiKeyHashes are the hex SHA-256
+// hashes of the API keys clients may send in ApiKeyHeader.
+type RateLimitConfiguration struct {
+	Enabled      bool
+	Backend      string
+	ApiKeyHeader string
+	ApiKeyHashes []string
+	Policies     []RateLimitPolicyConfiguration
+}
+
+// RateLimitPolicyConfiguration allows Limit requests per Period, in bursts
+// of up to Burst requests (Limit by default), for each value of Key: ip,
+// user (the subject of the token, or else the IP) or apiKey (the API key
+// header if it holds a known API key, or else the IP). An empty Method matches every method. In Path,
+// ":name" matches any segment and a final "*" anything after it.
+type RateLimitPolicyConfiguration struct {
+	Name   string
+	Method string
+	Path   string
+	Key    string
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// PasswordConfiguration holds the settings of the forgot password flow.
+// ResetURL is the page the emailed links lead to, with the token
+// added as a "token" query parameter.
+type PasswordConfiguration struct {
+	ResetTokenTTL time.Duration
+	ResetURL      string
+	Hashing       PasswordHashingConfiguration
+	Policy        PasswordPolicyConfiguration
+	Expiry        PasswordExpiryConfiguration
+}
+
+// PasswordExpiryConfiguration sets how long passwords last, passwords
+// never expiring when MaxAge is 0. Users are warned by email ReminderBefore
+// their password expires, the reminders being sent every ReminderInterval.
+type PasswordExpiryConfiguration struct {
+	MaxAge           time.Duration
+	ReminderBefore   time.Duration
+	ReminderInterval time.Duration
+}
+
+// PasswordPolicyConfiguration sets the rules every new password follows
+type PasswordPolicyConfiguration struct {
+	MinLength        int
+	MaxLength        int
+	RequireLowercase bool
+	RequireUppercase bool
+	RequireDigit     bool
+	RequireSpecial   bool
+	// Lowest strength score accepted, from 0 (too guessable) to 4
+	MinStrength int
+	// Number of previous passwords that cannot be reused
+	HistorySize int
+	// Directory of the SHA-1 range files of breached passwords,
+	// no password being checked when empty. The server does not
+	// start when it is set but cannot be read.
+	BreachedPasswordsDir string
+}
+
+// PasswordHashingConfiguration selects the algorithm new passwords are
+// hashed with, argon2id or bcrypt. Existing hashes of the other algorithm,
+// or with other parameters, are rehashed when their user logs in. At most
+// MaxConcurrent passwords are hashed or verified at once, one per CPU when
+// it is 0, the others waiting their turn, since each argon2id hash takes
+// Memory KiB.
+type PasswordHashingConfiguration struct {
+	Algorithm     string
+	Argon2id      Argon2idConfiguration
+	BcryptCost    int
+	MaxConcurrent int
+}
+
+// Argon2idConfiguration holds the argon2id parameters, Memory being in KiB
+type Argon2idConfiguration struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// InvitationConfiguration holds the settings of user invitations.
+// AcceptURL is the page the emailed links lead to, with the token
+// added as a "token" query parameter.
+type InvitationConfiguration struct {
+	TokenTTL  time.Duration
+	AcceptURL string
+}
+
+// EmailConfiguration holds the settings of email address verification.
+// VerifyURL is the page the emailed links lead to, with the token
+// added as a "token" query parameter.
+type EmailConfiguration struct {
+	VerificationTokenTTL time.Duration
+	VerifyURL            string
+}
+
+// MailConfiguration selects how emails are sent. Driver is either
+// smtp, or log to write them to File, or to stdout if File is empty.
+type MailConfiguration struct {
+	Driver string
+	From   string
+	File   string
+	Smtp   SmtpConfiguration
+}
+
+type SmtpConfiguration struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// StorageConfiguration selects the database users, and only users, are
+// stored in: mongo, in the collection of the Mongo configuration, or
+// postgres or sqlite, at DSN. Mongo is required whatever the driver, since
+// everything else, such as sessions, tokens, roles and audit events,
+// stays there. SQLite needs the server built with cgo, as the Docker
+// image is.
+type StorageConfiguration struct {
+	Driver string
+	DSN    string
+}
+
+// MigrationsConfiguration tells whether the server applies pending
+// migrations on start. When it does not, they are applied with the
+// migrate command, and the server only warns about them.
+type MigrationsConfiguration struct {
+	RunOnStart bool
+}
+
+// BootstrapConfiguration describes the first admin, created on start when
+// Enabled and there are no users yet. Without Password nor PasswordFile,
+// the password is generated and printed once. Either way it has to be
+// changed on the first login, since it is known to more than the admin.
+// Disabled, the first admin is created with the user create command.
+// The BOOTSTRAP_ADMIN_* environment variables override these.
+type BootstrapConfiguration struct {
+	Enabled      bool
+	Name         string
+	Email        string
+	Title        string
+	Password     string
+	PasswordFile string
+}
+
 type MongoConfiguration struct {
 	Server     string
 	Database   string
 	Collection string
 }
 
+var (
+	loadOnce  sync.Once
+	loaded    Configuration
+	loadError error
+)
+
+// GetConfig returns the configuration, read from config/config.yml the
+// first time it is called and kept since. Without the file, as in the
+// tests of packages, the defaults stand in for it, and Check fails.
 func GetConfig() Configuration {
-	conf := Configuration{}
+	loadOnce.Do(load)
+	return loaded
+}
+
+// Check reports why the configuration file could not be read, if it
+// could not, for the server and the commands to refuse to run without it
+func Check() error {
+	loadOnce.Do(load)
+	return loadError
+}
 
+func load() {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yml")
 	viper.AddConfigPath("./config")
 
-	err := viper.ReadInConfig()
-	if err != nil {
-		panic(err)
-	}
+	viper.SetDefault("auth.accessTokenTTL", "15m")
+	viper.SetDefault("auth.refreshTokenTTL", "720h")
+	viper.SetDefault("auth.mfaTokenTTL", "5m")
+	viper.SetDefault("auth.passwordChangeTokenTTL", "10m")
+	viper.SetDefault("auth.mfaIssuer", "User Management")
+	viper.SetDefault("jwt.algorithm", "RS256")
+	viper.SetDefault("jwt.rotationInterval", "720h")
+	viper.SetDefault("jwt.refreshInterval", "1m")
+	viper.SetDefault("users.deletedRetention", "720h")
+	viper.SetDefault("users.purgeInterval", "1h")
+	viper.SetDefault("lockout.maxAccountFailures", 5)
+	viper.SetDefault("lockout.maxIPFailures", 20)
+	viper.SetDefault("lockout.maxMfaTokenFailures", 3)
+	viper.SetDefault("lockout.duration", "15m")
+	viper.SetDefault("lockout.window", "15m")
+	viper.SetDefault("lockout.delayStep", "1s")
+	viper.SetDefault("lockout.maxDelay", "30s")
+	viper.SetDefault("rateLimit.enabled", true)
+	viper.SetDefault("rateLimit.backend", "memory")
+	viper.SetDefault("rateLimit.apiKeyHeader", "X-API-Key")
+	viper.SetDefault("password.resetTokenTTL", "1h")
+	viper.SetDefault("password.hashing.algorithm", "argon2id")
+	viper.SetDefault("password.hashing.argon2id.memory", 65536)
+	viper.SetDefault("password.hashing.argon2id.iterations", 3)
+	viper.SetDefault("password.hashing.argon2id.parallelism", 2)
+	viper.SetDefault("password.hashing.argon2id.saltLength", 16)
+	viper.SetDefault("password.hashing.argon2id.keyLength", 32)
+	viper.SetDefault("password.hashing.bcryptCost", 14)
+	viper.SetDefault("password.hashing.maxConcurrent", 0)
+	viper.SetDefault("password.policy.minLength", 8)
+	viper.SetDefault("password.policy.maxLength", 128)
+	viper.SetDefault("password.policy.requireLowercase", true)
+	viper.SetDefault("password.policy.requireUppercase", true)
+	viper.SetDefault("password.policy.requireDigit", true)
+	viper.SetDefault("password.policy.requireSpecial", true)
+	viper.SetDefault("password.policy.minStrength", 2)
+	viper.SetDefault("password.policy.historySize", 5)
+	viper.SetDefault("password.expiry.maxAge", "2160h")
+	viper.SetDefault("password.expiry.reminderBefore", "168h")
+	viper.SetDefault("password.expiry.reminderInterval", "24h")
+	viper.SetDefault("auth.requireVerifiedEmail", false)
+	viper.SetDefault("invitation.tokenTTL", "168h")
+	viper.SetDefault("email.verificationTokenTTL", "48h")
+	viper.SetDefault("users.storage.driver", "mongo")
+	viper.SetDefault("migrations.runOnStart", true)
+	viper.SetDefault("bootstrap.enabled", false)
+	viper.SetDefault("bootstrap.name", "Admin")
+	viper.SetDefault("bootstrap.title", "Administrator")
+	viper.SetDefault("mail.driver", "log")
+	viper.SetDefault("mail.smtp.port", 587)
 
-	err = viper.Unmarshal(&conf)
-	if err != nil {
+	viper.BindEnv("bootstrap.enabled", "BOOTSTRAP_ADMIN_ENABLED")
+	viper.BindEnv("bootstrap.name", "BOOTSTRAP_ADMIN_NAME")
+	viper.BindEnv("bootstrap.email", "BOOTSTRAP_ADMIN_EMAIL")
+	viper.BindEnv("bootstrap.title", "BOOTSTRAP_ADMIN_TITLE")
+	viper.BindEnv("bootstrap.password", "BOOTSTRAP_ADMIN_PASSWORD")
+	viper.BindEnv("bootstrap.passwordFile", "BOOTSTRAP_ADMIN_PASSWORD_FILE")
+
+	loadError = viper.ReadInConfig()
+
+	if err := viper.Unmarshal(&loaded); err != nil {
 		panic(err)
 	}
-
-	return conf
 }
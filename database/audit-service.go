@@ -0,0 +1,186 @@
+package database
+
+import (
+	"fmt"
+	"server/models"
+	"server/validators"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var AUDIT_EVENTS_COLLECTION = "audit_events"
+
+// Actions recorded in the audit log
+var (
+	AUDIT_ACTION_USER_CREATE          = "user.create"
+	AUDIT_ACTION_USER_UPDATE          = "user.update"
+	AUDIT_ACTION_USER_DELETE          = "user.delete"
+	AUDIT_ACTION_USER_RESTORE         = "user.restore"
+	AUDIT_ACTION_USER_PASSWORD_RESET  = "user.password.reset"
+	AUDIT_ACTION_USER_PASSWORD_CHANGE = "user.password.change"
+	AUDIT_ACTION_USER_PROFILE_UPDATE  = "user.profile.update"
+	AUDIT_ACTION_USER_EMAIL_VERIFY    = "user.email.verify"
+	AUDIT_ACTION_USER_UNLOCK          = "user.unlock"
+	AUDIT_ACTION_USER_TOKEN_ISSUE     = "user.token.issue"
+
+	AUDIT_ACTION_USER_SESSION_REVOKE      = "user.session.revoke"
+	AUDIT_ACTION_USER_SIGN_OUT_EVERYWHERE = "user.session.revoke_all"
+
+	AUDIT_ACTION_USER_INVITE            = "user.invite"
+	AUDIT_ACTION_USER_INVITATION_RESEND = "user.invitation.resend"
+	AUDIT_ACTION_USER_INVITATION_REVOKE = "user.invitation.revoke"
+	AUDIT_ACTION_USER_INVITATION_ACCEPT = "user.invitation.accept"
+
+	AUDIT_ACTION_ROLE_CREATE = "role.create"
+	AUDIT_ACTION_ROLE_UPDATE = "role.update"
+	AUDIT_ACTION_ROLE_DELETE = "role.delete"
+)
+
+// SystemActor performs the mutations no user asked for,
+// such as the creation of the bootstrap admin
+var SystemActor = models.Actor{ID: "system"}
+
+// CommandLineActor performs the mutations of the administrative
+// commands, run by whoever has access to the server and its config
+var CommandLineActor = models.Actor{ID: "cli", UserAgent: "cli"}
+
+func auditEventsCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(AUDIT_EVENTS_COLLECTION)
+}
+
+// recordAuditEvent writes an event to the audit log. changes is only
+// set for updates, and may be nil. Events are recorded once the change
+// they describe is made, which a failure to record them cannot undo,
+// so failures are logged along with the event rather than reported.
+func recordAuditEvent(dbClient *UsersClient, actor models.Actor, action string, targetID string, changes map[string]models.FieldChange) {
+	event := models.AuditEvent{
+		ActorID:   actor.ID,
+		Action:    action,
+		TargetID:  targetID,
+		Changes:   changes,
+		IP:        actor.IP,
+		UserAgent: actor.UserAgent,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := auditEventsCollection(dbClient).InsertOne(dbClient.Ctx, event)
+	if err != nil {
+		fmt.Printf("Error recording audit event %+v: %v\n", event, err)
+	}
+}
+
+// diffUsers returns the editable fields that differ between before and after
+func diffUsers(before models.User, after models.User) map[string]models.FieldChange {
+	changes := map[string]models.FieldChange{}
+
+	fields := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+	}{
+		{"name", before.Name, after.Name},
+		{"email", before.Email, after.Email},
+		{"pendingEmail", before.PendingEmail, after.PendingEmail},
+		{"title", before.Title, after.Title},
+		{"birthdate", before.Birthdate.Format(DATE_FORMAT), after.Birthdate.Format(DATE_FORMAT)},
+	}
+	for _, field := range fields {
+		if field.before != field.after {
+			changes[field.name] = models.FieldChange{Before: field.before, After: field.after}
+		}
+	}
+
+	if !sameRoles(before.Roles, after.Roles) {
+		changes["roles"] = models.FieldChange{Before: nonNilRoles(before.Roles), After: nonNilRoles(after.Roles)}
+	}
+
+	return changes
+}
+
+// GetAuditEvents returns one page of the events matching the
+// filters of args, newest first
+func GetAuditEvents(dbClient *UsersClient, args models.GetAuditArgs) (models.AuditPage, fiber.Error) {
+	page := models.AuditPage{Events: make([]models.AuditEvent, 0)}
+
+	validationError := validators.ValidateGetAuditArgs(args, MAX_PAGE_SIZE)
+	if validationError != nil {
+		return page, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	if args.Limit == 0 {
+		args.Limit = DEFAULT_PAGE_SIZE
+	}
+
+	query := auditFilterQuery(args)
+
+	total, err := auditEventsCollection(dbClient).CountDocuments(dbClient.Ctx, query)
+	if err != nil {
+		return page, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+	page.Total = total
+
+	// Ids grow with time, so the cursor is the id of the last event
+	if args.Cursor != "" {
+		last, err := primitive.ObjectIDFromHex(args.Cursor)
+		if err != nil {
+			return page, fiber.Error{Code: fiber.StatusBadRequest, Message: errInvalidCursor.Error()}
+		}
+
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$lt", Value: last}}})
+	}
+
+	// Fetch one extra event to know whether there is a next page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(args.Limit + 1))
+
+	cursor, err := auditEventsCollection(dbClient).Find(dbClient.Ctx, query, opts)
+	if err != nil {
+		return page, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err = cursor.All(dbClient.Ctx, &page.Events); err != nil {
+		return page, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if len(page.Events) > args.Limit {
+		page.Events = page.Events[:args.Limit]
+		page.NextCursor = page.Events[len(page.Events)-1].ID
+	}
+
+	return page, fiber.Error{}
+}
+
+// auditFilterQuery turns the filters of args into a query.
+// Arguments are expected to be validated already.
+func auditFilterQuery(args models.GetAuditArgs) bson.D {
+	query := bson.D{}
+
+	if args.ActorID != "" {
+		query = append(query, bson.E{Key: "actorId", Value: args.ActorID})
+	}
+	if args.Action != "" {
+		query = append(query, bson.E{Key: "action", Value: args.Action})
+	}
+	if args.TargetID != "" {
+		query = append(query, bson.E{Key: "targetId", Value: args.TargetID})
+	}
+
+	createdAt := bson.D{}
+	if date, ok := parseFilterDate(args.After); ok {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: date})
+	}
+	if date, ok := parseFilterDate(args.Before); ok {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: date})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "createdAt", Value: createdAt})
+	}
+
+	return query
+}
@@ -2,10 +2,14 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"os"
 	"server/config"
 	"server/models"
+	"server/security"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,24 +29,42 @@ func connectDB(ctx context.Context, conf config.MongoConfiguration) *mongo.Datab
 	return client.Database(conf.Database)
 }
 
-func SetupDatabaseClient() *UsersClient {
+// ConnectDatabaseClient connects to the databases,
+// without migrating them or seeding anything
+func ConnectDatabaseClient() *UsersClient {
 	conf := config.GetConfig()
 	ctx := context.TODO()
 
 	db := connectDB(ctx, conf.Mongo)
 	collection := db.Collection(conf.Mongo.Collection)
 
-	client := &UsersClient{
-		Col: collection,
-		Ctx: ctx,
+	users, err := connectUserRepository(ctx, conf.Users.Storage, collection)
+	if err != nil {
+		panic(err)
+	}
+
+	return &UsersClient{
+		DB:    db,
+		Col:   collection,
+		Ctx:   ctx,
+		Users: users,
+	}
+}
+
+func SetupDatabaseClient() *UsersClient {
+	client := ConnectDatabaseClient()
+
+	err := runMigrationsOnStart(client, config.GetConfig().Migrations)
+	if err != nil {
+		panic(err)
 	}
 
-	err := createIndices(client)
+	err = RotateSigningKeys(client)
 	if err != nil {
 		panic(err)
 	}
 
-	err = createDefaultAdmin(client)
+	err = createDefaultRoles(client)
 	if err != nil {
 		panic(err)
 	}
@@ -50,6 +72,70 @@ func SetupDatabaseClient() *UsersClient {
 	return client
 }
 
+// runMigrationsOnStart applies the pending migrations when configured to,
+// and otherwise warns about them
+func runMigrationsOnStart(client *UsersClient, conf config.MigrationsConfiguration) error {
+	if !conf.RunOnStart {
+		pending, err := MigrateUp(client, true)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			fmt.Println("Pending migration", migration.Store, migration.Version, migration.Name)
+		}
+		return nil
+	}
+
+	migrated, err := MigrateUp(client, false)
+	for _, migration := range migrated {
+		fmt.Println("Applied migration", migration.Store, migration.Version, migration.Name)
+	}
+
+	return err
+}
+
+// connectUserRepository opens the user repository the storage configuration selects
+func connectUserRepository(ctx context.Context, conf config.StorageConfiguration, collection *mongo.Collection) (UserRepository, error) {
+	if conf.Driver == "mongo" {
+		return NewMongoUserRepository(collection), nil
+	}
+
+	db, dialect, err := OpenSQLDatabase(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Users are stored in " + conf.Driver + ", everything else in Mongo")
+	return NewSQLUserRepository(db, dialect), nil
+}
+
+// OpenSQLDatabase connects to the SQL database
+// users are stored in, as configured by conf
+func OpenSQLDatabase(ctx context.Context, conf config.StorageConfiguration) (*sql.DB, SQLDialect, error) {
+	dialect, ok := SQL_DIALECTS[conf.Driver]
+	if !ok {
+		return nil, dialect, fmt.Errorf("unknown storage driver %q", conf.Driver)
+	}
+
+	db, err := sql.Open(dialect.DriverName, conf.DSN)
+	if err != nil {
+		return nil, dialect, err
+	}
+
+	if dialect.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(dialect.MaxOpenConns)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, dialect, err
+	}
+
+	fmt.Println("User database connected!")
+	return db, dialect, nil
+}
+
 func createIndices(usersClient *UsersClient) error {
 
 	// Create an index model for the field: email
@@ -64,33 +150,245 @@ func createIndices(usersClient *UsersClient) error {
 		return err
 	}
 
+	// Create a text index for user search, where
+	// names weigh more than emails, and emails than titles
+	textMod := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "name", Value: "text"},
+			{Key: "email", Value: "text"},
+			{Key: "title", Value: "text"},
+		},
+		Options: options.Index().
+			SetName("user_search").
+			SetWeights(bson.D{
+				{Key: "name", Value: 10},
+				{Key: "email", Value: 5},
+				{Key: "title", Value: 2},
+			}),
+	}
+
+	_, err = usersClient.Col.Indexes().CreateOne(usersClient.Ctx, textMod)
+	if err != nil {
+		return err
+	}
+
+	// Users are looked up by role when a role changes,
+	// and listings can be sorted on the following fields.
+	// _id is the tie-breaker of paginated listings.
+	userIndices := []mongo.IndexModel{
+		{Keys: bson.M{"roles": 1}},
+		{Keys: bson.M{"deletedAt": 1}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "updatedAt", Value: 1}, {Key: "_id", Value: 1}}},
+	}
+
+	_, err = usersClient.Col.Indexes().CreateMany(usersClient.Ctx, userIndices)
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens are looked up by hash and grouped by family.
+	// Expired ones are removed by mongo through a TTL index.
+	refreshTokenIndices := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"family": 1},
+		},
+		{
+			Keys: bson.M{"userId": 1},
+		},
+		{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = refreshTokensCollection(usersClient).Indexes().CreateMany(usersClient.Ctx, refreshTokenIndices)
+	if err != nil {
+		return err
+	}
+
+	// Revocations are only kept until the tokens they cover expire
+	revokedTokenIndex := mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err = revokedTokensCollection(usersClient).Indexes().CreateOne(usersClient.Ctx, revokedTokenIndex)
+	if err != nil {
+		return err
+	}
+
+	signingKeyIndex := mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err = signingKeysCollection(usersClient).Indexes().CreateOne(usersClient.Ctx, signingKeyIndex)
+	if err != nil {
+		return err
+	}
+
+	passwordResetTokenIndices := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"userId": 1},
+		},
+		{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = passwordResetTokensCollection(usersClient).Indexes().CreateMany(usersClient.Ctx, passwordResetTokenIndices)
+	if err != nil {
+		return err
+	}
+
+	emailVerificationTokenIndices := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"userId": 1},
+		},
+		{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = emailVerificationTokensCollection(usersClient).Indexes().CreateMany(usersClient.Ctx, emailVerificationTokenIndices)
+	if err != nil {
+		return err
+	}
+
+	// Sessions are listed per user, and forgotten once expired
+	sessionIndices := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastSeenAt", Value: -1}},
+		},
+		{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err = sessionsCollection(usersClient).Indexes().CreateMany(usersClient.Ctx, sessionIndices)
+	if err != nil {
+		return err
+	}
+
+	// IPs are forgotten once their failed logins are
+	loginAttemptsIndex := mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err = loginAttemptsCollection(usersClient).Indexes().CreateOne(usersClient.Ctx, loginAttemptsIndex)
+	if err != nil {
+		return err
+	}
+
+	// Audit events are listed newest first, optionally
+	// filtered by actor, action or target
+	auditEventIndices := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+	}
+
+	_, err = auditEventsCollection(usersClient).Indexes().CreateMany(usersClient.Ctx, auditEventIndices)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func createDefaultAdmin(usersClient *UsersClient) error {
-	users, err := GetAll(usersClient)
-	if (fiber.Error{}) != err {
-		return errors.New(err.Error())
-	}
-
-	if len(users) == 0 {
-		fmt.Println("Creating default admin")
-		user := models.CreateDefaultAdminArgs{
-			Password:          "defaultPassword1!",
-			CreateByAdminArgs: models.CreateByAdminArgs{
-				Name:      "John Doe",
-				Email:     "admin@gmail.com",
-				Title:     "Default Admin",
-				Birthdate: "1970-01-01",
-				IsAdmin:   true,
-			},
-		}
+// BootstrapAdmin creates the first admin as configured, unless disabled
+// or there are users already. Replicas starting together may all try:
+// the email being unique, only one of them creates the admin, and only
+// that one prints the generated password.
+func BootstrapAdmin(usersClient *UsersClient, conf config.BootstrapConfiguration) error {
+	if !conf.Enabled {
+		return nil
+	}
 
-		err := CreateDefaultAdmin(usersClient, user)
-		if (fiber.Error{}) != err {
-			return errors.New(err.Error())
+	count := int64(0)
+	for _, deleted := range []bool{false, true} {
+		query := UserListQuery{GetAllArgs: models.GetAllArgs{Limit: 1, Sort: "createdAt", Order: "asc"}, Deleted: deleted}
+
+		_, total, err := usersClient.Users.List(usersClient.Ctx, query)
+		if err != nil {
+			return err
 		}
+		count += total
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	password, generated, err := bootstrapPassword(conf)
+	if err != nil {
+		return err
+	}
+
+	user := models.CreateWithPasswordArgs{
+		Password:           password,
+		MustChangePassword: true,
+		CreateByAdminArgs: models.CreateByAdminArgs{
+			Name:      conf.Name,
+			Email:     conf.Email,
+			Title:     conf.Title,
+			Birthdate: "1970-01-01",
+			Roles:     []string{security.ROLE_ADMIN},
+		},
+	}
+
+	_, createError := CreateWithPassword(usersClient, SystemActor, user)
+	if createError.Code == fiber.StatusConflict {
+		return nil
+	}
+	if (fiber.Error{}) != createError {
+		return errors.New("bootstrap admin: " + createError.Message)
+	}
+
+	fmt.Println("Created bootstrap admin", conf.Email)
+	if generated {
+		fmt.Println("Bootstrap admin password, shown only once:", password)
 	}
 
 	return nil
 }
+
+// bootstrapPassword returns the password of the bootstrap admin,
+// telling whether it was generated for lack of a configured one
+func bootstrapPassword(conf config.BootstrapConfiguration) (string, bool, error) {
+	if conf.Password != "" {
+		return conf.Password, false, nil
+	}
+
+	if conf.PasswordFile != "" {
+		content, err := os.ReadFile(conf.PasswordFile)
+		if err != nil {
+			return "", false, err
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), false, nil
+	}
+
+	password, err := security.NewRandomPassword()
+	return password, true, err
+}
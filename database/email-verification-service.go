@@ -0,0 +1,230 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/config"
+	"server/mailer"
+	"server/models"
+	"server/security"
+	"server/validators"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var EMAIL_VERIFICATION_TOKENS_COLLECTION = "email_verification_tokens"
+
+func emailVerificationTokensCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(EMAIL_VERIFICATION_TOKENS_COLLECTION)
+}
+
+// sendEmailVerification issues a verification token for email, replacing
+// the ones user was sent before, and emails the link to use it to email
+func sendEmailVerification(dbClient *UsersClient, user models.User, email string) fiber.Error {
+	conf := config.GetConfig().Email
+
+	token, err := security.NewOpaqueToken()
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	// Only the latest link works
+	_, err = emailVerificationTokensCollection(dbClient).DeleteMany(dbClient.Ctx, bson.D{{Key: "userId", Value: user.ID}})
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	verificationToken := models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     email,
+		TokenHash: security.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(conf.VerificationTokenTTL),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = emailVerificationTokensCollection(dbClient).InsertOne(dbClient.Ctx, verificationToken)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	message := mailer.EmailVerificationMessage(email, user.Name, tokenLink(conf.VerifyURL, token), conf.VerificationTokenTTL)
+	if err := mailer.Send(message); err != nil {
+		fmt.Println("Error sending email verification:", err)
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// requestEmailChange sets newEmail as the pending email of user, and sends
+// it a verification link. The current address stays in use until then,
+// and is notified of the change.
+func requestEmailChange(dbClient *UsersClient, user models.User, newEmail string) fiber.Error {
+	newEmail = normalizeEmail(newEmail)
+	_, err := modifyActiveUser(dbClient.Ctx, dbClient.Users, user.ID, func(user *models.User) error {
+		user.PendingEmail = newEmail
+		return nil
+	})
+	if err != nil {
+		return userRepositoryError(err)
+	}
+
+	if err := mailer.Send(mailer.EmailChangeNoticeMessage(user.Email, user.Name, newEmail)); err != nil {
+		fmt.Println("Error sending email change notice:", err)
+	}
+
+	return sendEmailVerification(dbClient, user, newEmail)
+}
+
+// emailInUse reports whether a user other than id uses email
+func emailInUse(dbClient *UsersClient, email string, id primitive.ObjectID) (bool, fiber.Error) {
+	user, err := dbClient.Users.FindByEmail(dbClient.Ctx, email)
+	if err == ErrUserNotFound {
+		return false, fiber.Error{}
+	}
+	if err != nil {
+		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return user.ID != id.Hex(), fiber.Error{}
+}
+
+// ResendEmailVerification sends the user a new link to verify
+// their pending email if they have one, or else their email
+func ResendEmailVerification(dbClient *UsersClient, id primitive.ObjectID) fiber.Error {
+	user, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	if user.PendingEmail != "" {
+		return sendEmailVerification(dbClient, user, user.PendingEmail)
+	}
+
+	if user.EmailVerified {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_NOTHING_TO_VERIFY}
+	}
+
+	return sendEmailVerification(dbClient, user, user.Email)
+}
+
+// RequestEmailVerification sends a new verification link to the given
+// email, for users who cannot log in before verifying it. It succeeds
+// whether such a user exists or not, so that it cannot be used to find
+// out who has an account.
+func RequestEmailVerification(dbClient *UsersClient, args models.RequestEmailVerificationArgs) fiber.Error {
+	validationError := validators.ValidateRequestEmailVerificationArgs(args)
+	if validationError != nil {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	user, err := dbClient.Users.FindByEmail(dbClient.Ctx, args.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	// Invited users verify their email by accepting their invitation
+	if err != nil || !isActiveUser(user) || user.Status == models.USER_STATUS_INVITED || user.EmailVerified {
+		return fiber.Error{}
+	}
+
+	return sendEmailVerification(dbClient, user, user.Email)
+}
+
+// errStaleEmailToken aborts the verification of an address
+// its user neither uses nor moves to anymore
+var errStaleEmailToken = errors.New("stale email verification token")
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified. When it is the pending email of its user, it replaces
+// their current one. The token is only used up once the change is made,
+// so that a change failing, such as for the new address being taken
+// meanwhile, leaves the link working.
+func VerifyEmail(dbClient *UsersClient, actor models.Actor, args models.VerifyEmailArgs) (models.User, fiber.Error) {
+	user := models.User{}
+
+	validationError := validators.ValidateVerifyEmailArgs(args)
+	if validationError != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	now := time.Now()
+	verificationToken := models.EmailVerificationToken{}
+	query := bson.D{
+		{Key: "tokenHash", Value: security.HashOpaqueToken(args.Token)},
+		{Key: "usedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+
+	err := emailVerificationTokensCollection(dbClient).FindOne(dbClient.Ctx, query).Decode(&verificationToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return user, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_EMAIL_TOKEN}
+		}
+
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	id, err := primitive.ObjectIDFromHex(verificationToken.UserID)
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_EMAIL_TOKEN}
+	}
+
+	// The token is only valid for the address it was sent to,
+	// as long as the user still uses or moves to that address
+	previous := models.User{}
+	user, err = modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		previous = *user
+
+		switch normalizeEmail(verificationToken.Email) {
+		case user.PendingEmail:
+			user.Email = user.PendingEmail
+			user.PendingEmail = ""
+		case user.Email:
+		default:
+			return errStaleEmailToken
+		}
+
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		if err == ErrUserNotFound || err == errStaleEmailToken {
+			return models.User{}, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_EMAIL_TOKEN}
+		}
+
+		return models.User{}, userRepositoryError(err)
+	}
+
+	// Concurrent requests with the same token make the same change,
+	// the first one to use the token up recording it
+	usedQuery := bson.D{
+		{Key: "_id", Value: verificationToken.ID},
+		{Key: "usedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "usedAt", Value: now}}}}
+
+	result, err := emailVerificationTokensCollection(dbClient).UpdateOne(dbClient.Ctx, usedQuery, update)
+	if err != nil {
+		return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+	if result.ModifiedCount == 0 {
+		return GetSafeUser(user), fiber.Error{}
+	}
+
+	// The request is anonymous, the token tells who made it
+	if actor.ID == "" {
+		actor.ID = id.Hex()
+	}
+
+	changes := diffUsers(previous, user)
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_EMAIL_VERIFY, id.Hex(), changes)
+
+	return GetSafeUser(user), fiber.Error{}
+}
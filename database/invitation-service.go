@@ -0,0 +1,276 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/config"
+	"server/mailer"
+	"server/models"
+	"server/security"
+	"server/util"
+	"server/validators"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var INVITATION_TOKENS_COLLECTION = "invitation_tokens"
+
+func invitationTokensCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(INVITATION_TOKENS_COLLECTION)
+}
+
+// errNotInvited aborts changes meant for users who are still invited
+var errNotInvited = errors.New("user not invited")
+
+// isInvitedUser tells whether user still has to accept their invitation
+func isInvitedUser(user models.User) bool {
+	return isActiveUser(user) && user.Status == models.USER_STATUS_INVITED
+}
+
+// invitationError turns the error of a change meant for an invited
+// user into the error the API answers with
+func invitationError(err error, notInvited fiber.Error) fiber.Error {
+	if errors.Is(err, errNotInvited) || errors.Is(err, ErrUserNotFound) {
+		return notInvited
+	}
+
+	return userRepositoryError(err)
+}
+
+// CreateInvitation creates a user without password, and emails them
+// a link to choose one and activate their account. When the link cannot
+// be sent, the user is deleted again, for the invitation to be retried.
+func CreateInvitation(dbClient *UsersClient, actor models.Actor, args models.CreateByAdminArgs) (models.User, fiber.Error) {
+	user := models.User{}
+
+	validationError := validators.ValidateCreateByAdminArgs(args)
+	if validationError != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	if rolesError := checkRolesExist(dbClient, args.Roles); (fiber.Error{}) != rolesError {
+		return user, rolesError
+	}
+
+	// Parse args.Birthdate
+	birthdate, err := time.Parse(DATE_FORMAT, args.Birthdate)
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+
+	user = models.User{
+		Name:      args.Name,
+		Email:     args.Email,
+		Title:     args.Title,
+		Birthdate: birthdate,
+		Roles:     nonNilRoles(args.Roles),
+		Status:    models.USER_STATUS_INVITED,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	created, err := dbClient.Users.Create(dbClient.Ctx, user)
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	user, sendError := sendInvitation(dbClient, created)
+	if (fiber.Error{}) != sendError {
+		if err := dbClient.Users.Delete(dbClient.Ctx, created.ID); err != nil && !errors.Is(err, ErrUserNotFound) {
+			fmt.Println("Error deleting uninvited user", created.ID, err)
+		}
+		deleteInvitationTokens(dbClient, created.ID)
+
+		return models.User{}, sendError
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_INVITE, user.ID, nil)
+
+	return user, fiber.Error{}
+}
+
+// sendInvitation issues a new invitation token for user, which invalidates
+// the links they were sent before, and emails them the link to accept it
+func sendInvitation(dbClient *UsersClient, user models.User) (models.User, fiber.Error) {
+	conf := config.GetConfig().Invitation
+
+	token, err := security.NewOpaqueToken()
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	expiresAt := time.Now().Add(conf.TokenTTL)
+	user, err = modifyUser(dbClient.Ctx, dbClient.Users, user.ID, func(user *models.User) error {
+		if !isInvitedUser(*user) {
+			return errNotInvited
+		}
+
+		user.InvitationExpiresAt = &expiresAt
+		user.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return user, invitationError(err, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_INVITATION_NOT_FOUND})
+	}
+
+	// Only the latest link works
+	if deleteError := deleteInvitationTokens(dbClient, user.ID); (fiber.Error{}) != deleteError {
+		return user, deleteError
+	}
+
+	invitationToken := models.InvitationToken{
+		UserID:    user.ID,
+		TokenHash: security.HashOpaqueToken(token),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	_, err = invitationTokensCollection(dbClient).InsertOne(dbClient.Ctx, invitationToken)
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	link := tokenLink(conf.AcceptURL, token)
+	if err := mailer.Send(mailer.InvitationMessage(user.Email, user.Name, link, conf.TokenTTL)); err != nil {
+		fmt.Println("Error sending invitation email:", err)
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return GetSafeUser(user), fiber.Error{}
+}
+
+func deleteInvitationTokens(dbClient *UsersClient, userID string) fiber.Error {
+	_, err := invitationTokensCollection(dbClient).DeleteMany(dbClient.Ctx, bson.D{{Key: "userId", Value: userID}})
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// GetInvitations lists the users who have not accepted their invitation yet
+func GetInvitations(dbClient *UsersClient, args models.GetAllArgs) (models.UserPage, fiber.Error) {
+	return getUserPage(dbClient.Ctx, dbClient.Users, UserListQuery{GetAllArgs: args, Status: models.USER_STATUS_INVITED})
+}
+
+// ResendInvitation emails an invited user a new link, with a new expiry
+func ResendInvitation(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) (models.User, fiber.Error) {
+	user, err := dbClient.Users.Get(dbClient.Ctx, id.Hex())
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err != nil || !isInvitedUser(user) {
+		return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_INVITATION_NOT_FOUND}
+	}
+
+	user, sendError := sendInvitation(dbClient, user)
+	if (fiber.Error{}) != sendError {
+		return models.User{}, sendError
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_INVITATION_RESEND, id.Hex(), nil)
+
+	return user, fiber.Error{}
+}
+
+// RevokeInvitation deletes an invited user for good, since
+// they never had access, freeing their email address
+func RevokeInvitation(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) fiber.Error {
+	user, err := dbClient.Users.Get(dbClient.Ctx, id.Hex())
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err != nil || !isInvitedUser(user) {
+		return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_INVITATION_NOT_FOUND}
+	}
+
+	// Invitations are revoked for good, the user never having used their account
+	if err := dbClient.Users.Delete(dbClient.Ctx, user.ID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_INVITATION_NOT_FOUND}
+		}
+
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if deleteError := deleteInvitationTokens(dbClient, user.ID); (fiber.Error{}) != deleteError {
+		return deleteError
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_INVITATION_REVOKE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
+
+// AcceptInvitation sets the password of an invited user,
+// which activates their account
+func AcceptInvitation(dbClient *UsersClient, actor models.Actor, args models.AcceptInvitationArgs) (models.User, fiber.Error) {
+	user := models.User{}
+
+	validationError := validators.ValidateAcceptInvitationArgs(args)
+	if validationError != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	hashedPassword, err := util.HashPassword(args.Password)
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+
+	// Consume the token atomically, so that it can
+	// never be used by two concurrent requests
+	now := time.Now()
+	invitationToken := models.InvitationToken{}
+	query := bson.D{
+		{Key: "tokenHash", Value: security.HashOpaqueToken(args.Token)},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+
+	err = invitationTokensCollection(dbClient).FindOneAndDelete(dbClient.Ctx, query).Decode(&invitationToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return user, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_INVITATION}
+		}
+
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	id, err := primitive.ObjectIDFromHex(invitationToken.UserID)
+	if err != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_INVITATION}
+	}
+
+	user, err = modifyUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		if !isInvitedUser(*user) {
+			return errNotInvited
+		}
+
+		user.Password = hashedPassword
+		user.PasswordChangedAt = &now
+		user.Status = models.USER_STATUS_ACTIVE
+		// Following the emailed link proves the address is theirs
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		user.InvitationExpiresAt = nil
+		return nil
+	})
+	if err != nil {
+		return models.User{}, invitationError(err, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_INVITATION})
+	}
+
+	// The request is anonymous, the token tells who made it
+	if actor.ID == "" {
+		actor.ID = id.Hex()
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_INVITATION_ACCEPT, id.Hex(), nil)
+
+	return GetSafeUser(user), fiber.Error{}
+}
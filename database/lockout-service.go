@@ -0,0 +1,251 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"server/config"
+	"server/models"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var LOGIN_ATTEMPTS_COLLECTION = "login_attempts"
+
+func loginAttemptsCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(LOGIN_ATTEMPTS_COLLECTION)
+}
+
+// nextAttemptAt returns when state allows the next login attempt,
+// which is in the past when it is allowed already
+func nextAttemptAt(conf config.LockoutConfiguration, state models.LockoutState, now time.Time) time.Time {
+	if state.LockedUntil != nil && state.LockedUntil.After(now) {
+		return *state.LockedUntil
+	}
+
+	if state.FailedLoginCount == 0 || state.LastFailedLoginAt == nil || now.Sub(*state.LastFailedLoginAt) > conf.Window {
+		return time.Time{}
+	}
+
+	// The delay doubles at every failure in a row
+	delay := conf.MaxDelay
+	if state.FailedLoginCount < 32 {
+		delay = time.Duration(math.Min(float64(conf.DelayStep)*math.Pow(2, float64(state.FailedLoginCount-1)), float64(conf.MaxDelay)))
+	}
+
+	return state.LastFailedLoginAt.Add(delay)
+}
+
+// throttledLoginResult tells when state allows the next login attempt,
+// with an error if it is not allowed yet
+func throttledLoginResult(conf config.LockoutConfiguration, state models.LockoutState, lockedMessage string) (models.LoginResult, fiber.Error) {
+	result := models.LoginResult{}
+	now := time.Now()
+
+	allowedAt := nextAttemptAt(conf, state, now)
+	if !allowedAt.After(now) {
+		return result, fiber.Error{}
+	}
+
+	result.RetryAfter = int(math.Ceil(allowedAt.Sub(now).Seconds()))
+	if state.LockedUntil != nil && state.LockedUntil.After(now) {
+		result.LockedUntil = state.LockedUntil
+		return result, fiber.Error{Code: fiber.StatusLocked, Message: lockedMessage}
+	}
+
+	return result, fiber.Error{Code: fiber.StatusTooManyRequests, Message: ERROR_MESSAGE_TOO_MANY_ATTEMPTS}
+}
+
+func getLoginAttempts(dbClient *UsersClient, ip string) (models.LoginAttempts, fiber.Error) {
+	attempts := models.LoginAttempts{}
+
+	err := loginAttemptsCollection(dbClient).FindOne(dbClient.Ctx, bson.D{{Key: "_id", Value: ip}}).Decode(&attempts)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return attempts, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return attempts, fiber.Error{}
+}
+
+// nextLockoutState counts a failed login at now in state, and locks
+// it out once it reaches max failures, after which the count starts over
+func nextLockoutState(conf config.LockoutConfiguration, state models.LockoutState, max int, now time.Time) models.LockoutState {
+	// Failures older than the window start a new series
+	if state.LastFailedLoginAt == nil || now.Sub(*state.LastFailedLoginAt) > conf.Window {
+		state.FailedLoginCount = 0
+	}
+
+	state.FailedLoginCount++
+	state.LastFailedLoginAt = &now
+
+	if state.FailedLoginCount >= max {
+		lockedUntil := now.Add(conf.Duration)
+		state.LockedUntil = &lockedUntil
+		state.FailedLoginCount = 0
+	}
+
+	return state
+}
+
+// errLoginThrottled aborts counting a login attempt that is not allowed yet
+var errLoginThrottled = errors.New("login throttled")
+
+// lockoutUpdate sets the lockout fields of a document to state,
+// removing those it has not, as they are omitted when empty
+func lockoutUpdate(state models.LockoutState, set bson.D) bson.D {
+	unset := bson.D{}
+
+	if state.FailedLoginCount != 0 {
+		set = append(set, bson.E{Key: "failedLoginCount", Value: state.FailedLoginCount})
+	} else {
+		unset = append(unset, bson.E{Key: "failedLoginCount", Value: ""})
+	}
+	if state.LastFailedLoginAt != nil {
+		set = append(set, bson.E{Key: "lastFailedLoginAt", Value: state.LastFailedLoginAt})
+	} else {
+		unset = append(unset, bson.E{Key: "lastFailedLoginAt", Value: ""})
+	}
+	if state.LockedUntil != nil {
+		set = append(set, bson.E{Key: "lockedUntil", Value: state.LockedUntil})
+	} else {
+		unset = append(unset, bson.E{Key: "lockedUntil", Value: ""})
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	return update
+}
+
+// countIPLoginAttempt counts a login attempt from ip as failed, before
+// checking the password, unless ip has to wait before the next one. Each
+// attempt is counted on the state the previous one left, so that concurrent
+// attempts cannot go past the limits. It returns the states before and after.
+func countIPLoginAttempt(dbClient *UsersClient, ip string) (models.LockoutState, models.LockoutState, models.LoginResult, fiber.Error) {
+	conf := config.GetConfig().Lockout
+
+	for attempt := 0; attempt < MAX_MODIFY_ATTEMPTS; attempt++ {
+		attempts, err := getLoginAttempts(dbClient, ip)
+		if (fiber.Error{}) != err {
+			return models.LockoutState{}, models.LockoutState{}, models.LoginResult{}, err
+		}
+
+		before := attempts.LockoutState
+		if result, err := throttledLoginResult(conf, before, ERROR_MESSAGE_TOO_MANY_ATTEMPTS); (fiber.Error{}) != err {
+			return before, before, result, err
+		}
+
+		now := time.Now()
+		after := nextLockoutState(conf, before, conf.MaxIPFailures, now)
+
+		// Only when no other attempt was counted since the state was read,
+		// the first attempt creating the document
+		query := bson.D{
+			{Key: "_id", Value: ip},
+			{Key: "lastFailedLoginAt", Value: before.LastFailedLoginAt},
+		}
+		// IP documents disappear once their failures are forgotten
+		update := lockoutUpdate(after, bson.D{{Key: "expiresAt", Value: now.Add(conf.Window + conf.Duration)}})
+
+		opts := options.Update().SetUpsert(before.LastFailedLoginAt == nil)
+		result, updateError := loginAttemptsCollection(dbClient).UpdateOne(dbClient.Ctx, query, update, opts)
+		if updateError != nil && !mongo.IsDuplicateKeyError(updateError) {
+			return before, before, models.LoginResult{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		}
+		if updateError == nil && (result.MatchedCount > 0 || result.UpsertedCount > 0) {
+			return before, after, models.LoginResult{}, fiber.Error{}
+		}
+
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt+1) * int64(MODIFY_RETRY_DELAY))))
+	}
+
+	return models.LockoutState{}, models.LockoutState{}, models.LoginResult{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+}
+
+// uncountIPLoginAttempt takes back a login attempt from ip, counted from
+// state before to state after, that succeeded. When other attempts were
+// counted since, the state is left to them.
+func uncountIPLoginAttempt(dbClient *UsersClient, ip string, before models.LockoutState, after models.LockoutState) fiber.Error {
+	query := bson.D{
+		{Key: "_id", Value: ip},
+		{Key: "lastFailedLoginAt", Value: after.LastFailedLoginAt},
+	}
+
+	_, err := loginAttemptsCollection(dbClient).UpdateOne(dbClient.Ctx, query, lockoutUpdate(before, bson.D{}))
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// countAccountLoginAttempt counts a login attempt on the account of the user
+// with the given id as failed, before checking its credentials, unless the
+// account has to wait before the next one. The revision check of the update
+// keeps concurrent attempts from going past the limits. It returns the
+// user with the new lockout state.
+func countAccountLoginAttempt(dbClient *UsersClient, id string) (models.User, models.LoginResult, fiber.Error) {
+	conf := config.GetConfig().Lockout
+	result := models.LoginResult{}
+	throttled := fiber.Error{}
+
+	user, err := modifyUser(dbClient.Ctx, dbClient.Users, id, func(user *models.User) error {
+		result, throttled = throttledLoginResult(conf, user.LockoutState, ERROR_MESSAGE_ACCOUNT_LOCKED)
+		if (fiber.Error{}) != throttled {
+			return errLoginThrottled
+		}
+
+		user.LockoutState = nextLockoutState(conf, user.LockoutState, conf.MaxAccountFailures, time.Now())
+		return nil
+	})
+	if err == errLoginThrottled {
+		return models.User{}, result, throttled
+	}
+	if err != nil {
+		return models.User{}, result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return user, result, fiber.Error{}
+}
+
+// clearLoginFailures forgets the failed logins of a user who logged in,
+// including the attempt counted before checking their credentials
+func clearLoginFailures(dbClient *UsersClient, user models.User) fiber.Error {
+	if user.FailedLoginCount == 0 && user.LastFailedLoginAt == nil {
+		return fiber.Error{}
+	}
+
+	_, err := modifyUser(dbClient.Ctx, dbClient.Users, user.ID, func(user *models.User) error {
+		user.LockoutState = models.LockoutState{}
+		return nil
+	})
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// UnlockUser lifts the lockout of an account and forgets its failed logins
+func UnlockUser(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) (models.User, fiber.Error) {
+	user, err := modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		user.LockoutState = models.LockoutState{}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_UNLOCK, id.Hex(), nil)
+
+	return GetSafeUser(user), fiber.Error{}
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Stores whose schema is versioned by migrations of their own: the Mongo
+// database, and the SQL database users are stored in when there is one
+var (
+	MIGRATION_STORE_MONGO = "mongo"
+	MIGRATION_STORE_SQL   = "sql"
+)
+
+// MigrationStatus is a migration of one of the stores,
+// applied when AppliedAt is set
+type MigrationStatus struct {
+	Store     string     `json:"store"`
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// MigrateUp applies the pending migrations of Mongo, then of the SQL
+// database users are stored in, and returns the ones it applied, or
+// would apply when dryRun is set
+func MigrateUp(dbClient *UsersClient, dryRun bool) ([]MigrationStatus, error) {
+	migrated := []MigrationStatus{}
+
+	mongoMigrations, err := migrateMongoUp(dbClient, dryRun)
+	migrated = append(migrated, mongoMigrationStatuses(mongoMigrations, !dryRun)...)
+	if err != nil {
+		return migrated, err
+	}
+
+	if repository, ok := dbClient.Users.(*SQLUserRepository); ok {
+		sqlMigrations, err := MigrateSQLUp(dbClient.Ctx, repository.DB, repository.Dialect, dryRun)
+		migrated = append(migrated, sqlMigrationStatuses(sqlMigrations, !dryRun)...)
+		if err != nil {
+			return migrated, err
+		}
+	}
+
+	return migrated, nil
+}
+
+// MigrateDown reverts the last steps migrations of store, newest first,
+// and returns the ones it reverted, or would revert when dryRun is set
+func MigrateDown(dbClient *UsersClient, store string, steps int, dryRun bool) ([]MigrationStatus, error) {
+	switch store {
+	case MIGRATION_STORE_MONGO:
+		migrations, err := migrateMongoDown(dbClient, steps, dryRun)
+		return mongoMigrationStatuses(migrations, false), err
+	case MIGRATION_STORE_SQL:
+		repository, ok := dbClient.Users.(*SQLUserRepository)
+		if !ok {
+			return []MigrationStatus{}, fmt.Errorf("users are not stored in a SQL database")
+		}
+
+		migrations, err := MigrateSQLDown(dbClient.Ctx, repository.DB, repository.Dialect, steps, dryRun)
+		return sqlMigrationStatuses(migrations, false), err
+	}
+
+	return []MigrationStatus{}, fmt.Errorf("unknown migration store %q", store)
+}
+
+// GetMigrationStatus lists the migrations of every store, applied or not,
+// oldest first. Applied migrations this version does not know are listed too.
+func GetMigrationStatus(dbClient *UsersClient) ([]MigrationStatus, error) {
+	statuses := []MigrationStatus{}
+
+	applied, err := appliedMongoMigrations(dbClient)
+	if err != nil {
+		return statuses, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	known := map[int]bool{}
+	for _, migration := range sortedMongoMigrations() {
+		known[migration.Version] = true
+		statuses = append(statuses, newMigrationStatus(MIGRATION_STORE_MONGO, migration.Version, migration.Name, appliedAt))
+	}
+	for _, migration := range applied {
+		if !known[migration.Version] {
+			statuses = append(statuses, newMigrationStatus(MIGRATION_STORE_MONGO, migration.Version, migration.Name, appliedAt))
+		}
+	}
+
+	repository, ok := dbClient.Users.(*SQLUserRepository)
+	if !ok {
+		return statuses, nil
+	}
+
+	sqlApplied, err := AppliedSQLMigrations(dbClient.Ctx, repository.DB)
+	if err != nil {
+		return statuses, err
+	}
+
+	appliedAt = map[int]time.Time{}
+	for _, migration := range sqlApplied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	known = map[int]bool{}
+	for _, migration := range sortedSQLMigrations() {
+		known[migration.Version] = true
+		statuses = append(statuses, newMigrationStatus(MIGRATION_STORE_SQL, migration.Version, migration.Name, appliedAt))
+	}
+	for _, migration := range sqlApplied {
+		if !known[migration.Version] {
+			statuses = append(statuses, newMigrationStatus(MIGRATION_STORE_SQL, migration.Version, migration.Name, appliedAt))
+		}
+	}
+
+	return statuses, nil
+}
+
+func newMigrationStatus(store string, version int, name string, appliedAt map[int]time.Time) MigrationStatus {
+	status := MigrationStatus{Store: store, Version: version, Name: name}
+	if at, ok := appliedAt[version]; ok {
+		status.AppliedAt = &at
+	}
+
+	return status
+}
+
+// mongoMigrationStatuses describes migrations, as applied now if applied is set
+func mongoMigrationStatuses(migrations []MongoMigration, applied bool) []MigrationStatus {
+	statuses := []MigrationStatus{}
+	for _, migration := range migrations {
+		statuses = append(statuses, ranMigrationStatus(MIGRATION_STORE_MONGO, migration.Version, migration.Name, applied))
+	}
+
+	return statuses
+}
+
+// sqlMigrationStatuses describes migrations, as applied now if applied is set
+func sqlMigrationStatuses(migrations []SQLMigration, applied bool) []MigrationStatus {
+	statuses := []MigrationStatus{}
+	for _, migration := range migrations {
+		statuses = append(statuses, ranMigrationStatus(MIGRATION_STORE_SQL, migration.Version, migration.Name, applied))
+	}
+
+	return statuses
+}
+
+func ranMigrationStatus(store string, version int, name string, applied bool) MigrationStatus {
+	status := MigrationStatus{Store: store, Version: version, Name: name}
+	if applied {
+		now := time.Now()
+		status.AppliedAt = &now
+	}
+
+	return status
+}
@@ -0,0 +1,543 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/models"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	SCHEMA_MIGRATIONS_COLLECTION = "schema_migrations"
+	MIGRATION_LOCKS_COLLECTION   = "migration_locks"
+
+	// A replica holding the migration lock renews it while it migrates,
+	// and the others wait for it up to MIGRATION_LOCK_WAIT. The lock of
+	// a replica that died expires after MIGRATION_LOCK_TTL.
+	MIGRATION_LOCK_ID   = "migrations"
+	MIGRATION_LOCK_TTL  = time.Minute
+	MIGRATION_LOCK_WAIT = 10 * time.Minute
+)
+
+var errIrreversibleMigration = errors.New("migration cannot be reverted")
+
+func schemaMigrationsCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(SCHEMA_MIGRATIONS_COLLECTION)
+}
+
+func migrationLocksCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(MIGRATION_LOCKS_COLLECTION)
+}
+
+// MongoMigration changes the Mongo collections from the previous version
+// to Version when applied, and back when reverted, Down being nil when
+// it cannot be. Mongo cannot roll a migration back when it fails halfway,
+// so both have to be safe to run again over their own partial result.
+type MongoMigration struct {
+	Version int
+	Name    string
+	Up      func(dbClient *UsersClient) error
+	Down    func(dbClient *UsersClient) error
+	// UsersOnly migrations only change the users collection. When users
+	// are stored in SQL, whose schema starts out the way they leave it,
+	// they are recorded without running.
+	UsersOnly bool
+}
+
+// MONGO_MIGRATIONS are the versions of the Mongo collections, oldest
+// first. Applied migrations must never change: fixes go in new ones.
+var MONGO_MIGRATIONS = []MongoMigration{
+	{Version: 1, Name: "create_indices", Up: createIndices, Down: dropIndices},
+	// Setting isAdmin back would lose the roles given since
+	{Version: 2, Name: "migrate_is_admin_to_roles", Up: migrateIsAdminToRoles, UsersOnly: true},
+	{Version: 3, Name: "create_signing_key_successor_index", Up: createSigningKeySuccessorIndex, Down: dropSigningKeySuccessorIndex},
+	{Version: 4, Name: "create_mfa_attempts_index", Up: createMfaAttemptsIndex, Down: dropMfaAttemptsIndices},
+	{Version: 5, Name: "create_invitation_token_indices", Up: createInvitationTokenIndices, Down: dropInvitationTokenIndices},
+	// The original case of emails is lost
+	{Version: 6, Name: "lower_case_emails", Up: lowerCaseEmails, UsersOnly: true},
+	// Which users were verified by the backfill is not known
+	{Version: 7, Name: "backfill_email_verified", Up: backfillEmailVerified, UsersOnly: true},
+	// Which users had their password change date backfilled is not known
+	{Version: 8, Name: "backfill_password_changed_at", Up: backfillPasswordChangedAt, UsersOnly: true},
+	{Version: 9, Name: "restrict_email_index_to_undeleted_users", Up: restrictEmailIndexToUndeletedUsers, Down: extendEmailIndexToDeletedUsers, UsersOnly: true},
+}
+
+// appliedMongoMigration is a migration recorded in schema_migrations
+type appliedMongoMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+func appliedMongoMigrations(dbClient *UsersClient) ([]appliedMongoMigration, error) {
+	applied := []appliedMongoMigration{}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := schemaMigrationsCollection(dbClient).Find(dbClient.Ctx, bson.D{}, opts)
+	if err != nil {
+		return applied, err
+	}
+
+	if err := cursor.All(dbClient.Ctx, &applied); err != nil {
+		return applied, err
+	}
+
+	return applied, nil
+}
+
+// pendingMongoMigrations lists the migrations not applied yet, in order
+func pendingMongoMigrations(dbClient *UsersClient) ([]MongoMigration, error) {
+	pending := []MongoMigration{}
+
+	applied, err := appliedMongoMigrations(dbClient)
+	if err != nil {
+		return pending, err
+	}
+
+	done := map[int]bool{}
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	for _, migration := range sortedMongoMigrations() {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// revertibleMongoMigrations lists the last steps migrations applied,
+// newest first
+func revertibleMongoMigrations(dbClient *UsersClient, steps int) ([]MongoMigration, error) {
+	reverted := []MongoMigration{}
+
+	applied, err := appliedMongoMigrations(dbClient)
+	if err != nil {
+		return reverted, err
+	}
+
+	known := map[int]MongoMigration{}
+	for _, migration := range MONGO_MIGRATIONS {
+		known[migration.Version] = migration
+	}
+
+	for i := len(applied) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration, ok := known[applied[i].Version]
+		if !ok {
+			return reverted, fmt.Errorf("migration %d %s is unknown to this version", applied[i].Version, applied[i].Name)
+		}
+
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// migrateMongoUp applies the pending migrations in order, and returns
+// the ones it applied, or would apply when dryRun is set
+func migrateMongoUp(dbClient *UsersClient, dryRun bool) ([]MongoMigration, error) {
+	if dryRun {
+		return pendingMongoMigrations(dbClient)
+	}
+
+	migrated := []MongoMigration{}
+	err := withMigrationLock(dbClient, func() error {
+		// Other replicas may have migrated while this one waited
+		pending, err := pendingMongoMigrations(dbClient)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			if !skipMongoMigration(dbClient, migration) {
+				if err := migration.Up(dbClient); err != nil {
+					return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+				}
+			}
+
+			record := appliedMongoMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+			if _, err := schemaMigrationsCollection(dbClient).InsertOne(dbClient.Ctx, record); err != nil {
+				return err
+			}
+
+			migrated = append(migrated, migration)
+		}
+
+		return nil
+	})
+
+	return migrated, err
+}
+
+// migrateMongoDown reverts the last steps migrations, newest first, and
+// returns the ones it reverted, or would revert when dryRun is set.
+// It stops at the first migration that cannot be reverted.
+func migrateMongoDown(dbClient *UsersClient, steps int, dryRun bool) ([]MongoMigration, error) {
+	if dryRun {
+		return revertibleMongoMigrations(dbClient, steps)
+	}
+
+	reverted := []MongoMigration{}
+	err := withMigrationLock(dbClient, func() error {
+		migrations, err := revertibleMongoMigrations(dbClient, steps)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if !skipMongoMigration(dbClient, migration) {
+				if migration.Down == nil {
+					return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, errIrreversibleMigration)
+				}
+
+				if err := migration.Down(dbClient); err != nil {
+					return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+				}
+			}
+
+			query := bson.D{{Key: "_id", Value: migration.Version}}
+			if _, err := schemaMigrationsCollection(dbClient).DeleteOne(dbClient.Ctx, query); err != nil {
+				return err
+			}
+
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// skipMongoMigration tells whether migration only changes
+// the users, and they are not stored in Mongo
+func skipMongoMigration(dbClient *UsersClient, migration MongoMigration) bool {
+	_, usersInMongo := dbClient.Users.(*MongoUserRepository)
+	return migration.UsersOnly && !usersInMongo
+}
+
+// withMigrationLock runs migrate while holding the migration lock, which
+// only one replica holds at a time. The lock is a document whose expiry
+// its holder pushes back as long as it migrates, so that the lock of a
+// replica that died is not held forever.
+func withMigrationLock(dbClient *UsersClient, migrate func() error) error {
+	owner := primitive.NewObjectID().Hex()
+	deadline := time.Now().Add(MIGRATION_LOCK_WAIT)
+
+	for {
+		acquired, err := acquireMigrationLock(dbClient, owner)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the migration lock")
+		}
+		time.Sleep(time.Second)
+	}
+
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+
+		ticker := time.NewTicker(MIGRATION_LOCK_TTL / 4)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := acquireMigrationLock(dbClient, owner); err != nil {
+					fmt.Println("Error renewing the migration lock:", err)
+				}
+			}
+		}
+	}()
+
+	err := migrate()
+
+	close(done)
+	<-renewed
+
+	query := bson.D{{Key: "_id", Value: MIGRATION_LOCK_ID}, {Key: "owner", Value: owner}}
+	if _, releaseError := migrationLocksCollection(dbClient).DeleteOne(dbClient.Ctx, query); releaseError != nil && err == nil {
+		err = releaseError
+	}
+
+	return err
+}
+
+// acquireMigrationLock takes the lock for owner, or extends it if
+// owner holds it already. It reports false while another owner does.
+func acquireMigrationLock(dbClient *UsersClient, owner string) (bool, error) {
+	now := time.Now()
+	query := bson.D{
+		{Key: "_id", Value: MIGRATION_LOCK_ID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "owner", Value: owner}},
+			bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "owner", Value: owner},
+		{Key: "expiresAt", Value: now.Add(MIGRATION_LOCK_TTL)},
+	}}}
+
+	// Held by another owner, the lock does not match the query,
+	// and inserting it again hits the unique _id
+	_, err := migrationLocksCollection(dbClient).UpdateOne(dbClient.Ctx, query, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// SIGNING_KEY_SUCCESSOR_INDEX makes replaces unique, keys stored
+// before it existed having none
+var SIGNING_KEY_SUCCESSOR_INDEX = "signing_key_successor"
+
+func createSigningKeySuccessorIndex(dbClient *UsersClient) error {
+	index := mongo.IndexModel{
+		Keys: bson.M{"replaces": 1},
+		Options: options.Index().
+			SetName(SIGNING_KEY_SUCCESSOR_INDEX).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "replaces", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	}
+
+	_, err := signingKeysCollection(dbClient).Indexes().CreateOne(dbClient.Ctx, index)
+	return err
+}
+
+func dropSigningKeySuccessorIndex(dbClient *UsersClient) error {
+	_, err := signingKeysCollection(dbClient).Indexes().DropOne(dbClient.Ctx, SIGNING_KEY_SUCCESSOR_INDEX)
+	if err != nil && !isNamespaceNotFound(err) && !isIndexNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Failures of pending two-factor logins are
+// forgotten once their token expires
+func createMfaAttemptsIndex(dbClient *UsersClient) error {
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err := mfaAttemptsCollection(dbClient).Indexes().CreateOne(dbClient.Ctx, index)
+	return err
+}
+
+func dropMfaAttemptsIndices(dbClient *UsersClient) error {
+	_, err := mfaAttemptsCollection(dbClient).Indexes().DropAll(dbClient.Ctx)
+	if err != nil && !isNamespaceNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Invitation tokens are looked up by hash, replaced per
+// user, and forgotten once expired
+func createInvitationTokenIndices(dbClient *UsersClient) error {
+	indices := []mongo.IndexModel{
+		{
+			Keys:    bson.M{"tokenHash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"userId": 1},
+		},
+		{
+			Keys:    bson.M{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err := invitationTokensCollection(dbClient).Indexes().CreateMany(dbClient.Ctx, indices)
+	return err
+}
+
+func dropInvitationTokenIndices(dbClient *UsersClient) error {
+	_, err := invitationTokensCollection(dbClient).Indexes().DropAll(dbClient.Ctx)
+	if err != nil && !isNamespaceNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// lowerCaseEmails stores the emails of existing users the way users
+// are looked up since. It fails on addresses differing only by case,
+// whose users have to be told apart by hand before migrating again.
+func lowerCaseEmails(dbClient *UsersClient) error {
+	query := bson.D{{Key: "email", Value: bson.D{{Key: "$regex", Value: "[A-Z]"}}}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "email", Value: bson.D{{Key: "$toLower", Value: "$email"}}}}}},
+	}
+
+	_, err := dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("emails differing only by case: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	pendingQuery := bson.D{{Key: "pendingEmail", Value: bson.D{{Key: "$regex", Value: "[A-Z]"}}}}
+	pendingUpdate := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "pendingEmail", Value: bson.D{{Key: "$toLower", Value: "$pendingEmail"}}}}}},
+	}
+
+	_, err = dbClient.Col.UpdateMany(dbClient.Ctx, pendingQuery, pendingUpdate)
+	return err
+}
+
+// backfillEmailVerified counts the emails of the users created before
+// emails were verified as verified, so that requiring verified emails
+// does not lock them out
+func backfillEmailVerified(dbClient *UsersClient) error {
+	query := bson.D{{Key: "emailVerified", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "emailVerified", Value: true}}}}
+
+	_, err := dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	return err
+}
+
+// backfillPasswordChangedAt dates the passwords of the users created
+// before password changes were dated from the migration, so that
+// passwords older than the maximum age don't all expire at once
+func backfillPasswordChangedAt(dbClient *UsersClient) error {
+	query := bson.D{
+		{Key: "passwordChangedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: models.USER_STATUS_INVITED}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "passwordChangedAt", Value: time.Now()}}}}
+
+	_, err := dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	return err
+}
+
+// USER_EMAIL_INDEX is the index created by createIndices, unique on the
+// email of every user, and UNDELETED_USER_EMAIL_INDEX the one replacing
+// it, unique on the email of undeleted users only
+var (
+	USER_EMAIL_INDEX           = "email_1"
+	UNDELETED_USER_EMAIL_INDEX = "email_undeleted"
+)
+
+// restrictEmailIndexToUndeletedUsers lets the emails of soft deleted users
+// be used again. Partial indices cannot select documents missing a field,
+// so undeleted users are given a null deletedAt, which they have since.
+func restrictEmailIndexToUndeletedUsers(dbClient *UsersClient) error {
+	query := bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: nil}}}}
+	if _, err := dbClient.Col.UpdateMany(dbClient.Ctx, query, update); err != nil {
+		return err
+	}
+
+	_, err := dbClient.Col.Indexes().DropOne(dbClient.Ctx, USER_EMAIL_INDEX)
+	if err != nil && !isNamespaceNotFound(err) && !isIndexNotFound(err) {
+		return err
+	}
+
+	index := mongo.IndexModel{
+		Keys: bson.M{"email": 1},
+		Options: options.Index().
+			SetName(UNDELETED_USER_EMAIL_INDEX).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$type", Value: "null"}}}}),
+	}
+
+	_, err = dbClient.Col.Indexes().CreateOne(dbClient.Ctx, index)
+	return err
+}
+
+// extendEmailIndexToDeletedUsers reverts restrictEmailIndexToUndeletedUsers.
+// It fails when deleted users share their email with another user, one
+// of whom has to be purged or given another email before migrating again.
+func extendEmailIndexToDeletedUsers(dbClient *UsersClient) error {
+	_, err := dbClient.Col.Indexes().DropOne(dbClient.Ctx, UNDELETED_USER_EMAIL_INDEX)
+	if err != nil && !isNamespaceNotFound(err) && !isIndexNotFound(err) {
+		return err
+	}
+
+	index := mongo.IndexModel{
+		Keys:    bson.M{"email": 1},
+		Options: options.Index().SetName(USER_EMAIL_INDEX).SetUnique(true),
+	}
+	if _, err := dbClient.Col.Indexes().CreateOne(dbClient.Ctx, index); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("emails shared with deleted users: %w", err)
+		}
+		return err
+	}
+
+	// Undeleted users had no deletedAt before
+	query := bson.D{{Key: "deletedAt", Value: nil}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}}}}
+	_, err = dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	return err
+}
+
+// dropIndices reverts createIndices, dropping every
+// index of the collections it creates them on
+func dropIndices(dbClient *UsersClient) error {
+	collections := []*mongo.Collection{
+		dbClient.Col,
+		refreshTokensCollection(dbClient),
+		revokedTokensCollection(dbClient),
+		signingKeysCollection(dbClient),
+		passwordResetTokensCollection(dbClient),
+		emailVerificationTokensCollection(dbClient),
+		sessionsCollection(dbClient),
+		loginAttemptsCollection(dbClient),
+		auditEventsCollection(dbClient),
+	}
+
+	for _, collection := range collections {
+		if _, err := collection.Indexes().DropAll(dbClient.Ctx); err != nil && !isNamespaceNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isNamespaceNotFound tells whether err comes from
+// a collection that does not exist (yet)
+func isNamespaceNotFound(err error) bool {
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == 26
+}
+
+// isIndexNotFound tells whether err comes from an index that does not exist
+func isIndexNotFound(err error) bool {
+	var commandError mongo.CommandError
+	return errors.As(err, &commandError) && commandError.Code == 27
+}
+
+func sortedMongoMigrations() []MongoMigration {
+	migrations := append([]MongoMigration{}, MONGO_MIGRATIONS...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations
+}
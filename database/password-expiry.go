@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/config"
+	"server/mailer"
+	"server/models"
+	"time"
+)
+
+// passwordSetAt is when the password of user was last set, their
+// creation for users created before passwordChangedAt existed
+func passwordSetAt(user models.User) time.Time {
+	if user.PasswordChangedAt != nil {
+		return *user.PasswordChangedAt
+	}
+
+	return user.CreatedAt
+}
+
+// passwordExpired tells whether the password of user is older
+// than the maximum age, when passwords expire at all
+func passwordExpired(user models.User) bool {
+	maxAge := config.GetConfig().Password.Expiry.MaxAge
+	if maxAge <= 0 {
+		return false
+	}
+
+	return time.Since(passwordSetAt(user)) > maxAge
+}
+
+// SendPasswordExpiryReminders emails the users whose password expires
+// within the reminder period, once per password. Each reminder is claimed
+// before being sent, so that concurrent jobs don't send it twice, and
+// released when sending it fails, so that the next run tries again.
+func SendPasswordExpiryReminders(dbClient *UsersClient) (int, error) {
+	conf := config.GetConfig().Password.Expiry
+	// Stored to the millisecond, claims are told apart by it
+	now := time.Now().Truncate(time.Millisecond)
+
+	// Set between these two dates, passwords expire within the reminder period
+	setAfter := now.Add(-conf.MaxAge)
+	setBefore := now.Add(conf.ReminderBefore - conf.MaxAge)
+
+	// Deleted users are not listed, and invited ones have no password yet
+	due := []models.User{}
+	err := eachUser(dbClient.Ctx, dbClient.Users, UserListQuery{}, func(user models.User) error {
+		setAt := passwordSetAt(user)
+		if user.Status != models.USER_STATUS_INVITED && user.PasswordExpiryReminderAt == nil &&
+			setAt.After(setAfter) && !setAt.After(setBefore) {
+			due = append(due, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, user := range due {
+		_, err := modifyUser(dbClient.Ctx, dbClient.Users, user.ID, func(user *models.User) error {
+			if user.PasswordExpiryReminderAt != nil {
+				return errReminderClaimed
+			}
+
+			user.PasswordExpiryReminderAt = &now
+			return nil
+		})
+
+		// Another job got to it first
+		if err == errReminderClaimed {
+			continue
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		expiresAt := passwordSetAt(user).Add(conf.MaxAge)
+		if err := mailer.Send(mailer.PasswordExpiryMessage(user.Email, user.Name, expiresAt)); err != nil {
+			fmt.Println("Error sending password expiry reminder:", err)
+
+			if err := releaseReminder(dbClient, user.ID, now); err != nil {
+				return sent, err
+			}
+			continue
+		}
+
+		sent++
+	}
+
+	return sent, nil
+}
+
+// errReminderClaimed aborts claiming a reminder another job claimed
+var errReminderClaimed = errors.New("password expiry reminder already claimed")
+
+// releaseReminder forgets the reminder of the user with the given id
+// claimed at claimedAt, unless their password changed meanwhile
+func releaseReminder(dbClient *UsersClient, id string, claimedAt time.Time) error {
+	_, err := modifyUser(dbClient.Ctx, dbClient.Users, id, func(user *models.User) error {
+		if user.PasswordExpiryReminderAt == nil || !user.PasswordExpiryReminderAt.Equal(claimedAt) {
+			return errReminderClaimed
+		}
+
+		user.PasswordExpiryReminderAt = nil
+		return nil
+	})
+	if err == errReminderClaimed || err == ErrUserNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// StartPasswordExpiryReminders runs SendPasswordExpiryReminders in the
+// background every reminder interval, unless passwords never expire
+func StartPasswordExpiryReminders(dbClient *UsersClient) {
+	conf := config.GetConfig().Password.Expiry
+	if conf.MaxAge <= 0 {
+		return
+	}
+
+	if conf.ReminderInterval <= 0 {
+		panic(errors.New("password.expiry.reminderInterval must be positive"))
+	}
+
+	go func() {
+		ticker := time.NewTicker(conf.ReminderInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sent, err := SendPasswordExpiryReminders(dbClient)
+			if err != nil {
+				fmt.Println("Error sending password expiry reminders:", err)
+			} else if sent > 0 {
+				fmt.Println("Sent", sent, "password expiry reminders")
+			}
+		}
+	}()
+}
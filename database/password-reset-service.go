@@ -0,0 +1,146 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"server/config"
+	"server/mailer"
+	"server/models"
+	"server/security"
+	"server/validators"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var PASSWORD_RESET_TOKENS_COLLECTION = "password_reset_tokens"
+
+func passwordResetTokensCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(PASSWORD_RESET_TOKENS_COLLECTION)
+}
+
+// ForgotPassword emails a password reset link to the user with the given
+// email. It succeeds whether such a user exists or not, so that it cannot
+// be used to find out who has an account.
+func ForgotPassword(dbClient *UsersClient, args models.ForgotPasswordArgs) fiber.Error {
+	validationError := validators.ValidateForgotPasswordArgs(args)
+	if validationError != nil {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	user, err := dbClient.Users.FindByEmail(dbClient.Ctx, args.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	// Deleted and invited users cannot reset their password
+	if err != nil || !isActiveUser(user) || user.Status == models.USER_STATUS_INVITED {
+		return fiber.Error{}
+	}
+
+	return sendPasswordResetEmail(dbClient, user)
+}
+
+// sendPasswordResetEmail issues a reset token for user, replacing the ones
+// they were sent before, and emails them the link to use it
+func sendPasswordResetEmail(dbClient *UsersClient, user models.User) fiber.Error {
+	conf := config.GetConfig().Password
+
+	token, err := security.NewOpaqueToken()
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	// Only the latest link works
+	_, err = passwordResetTokensCollection(dbClient).DeleteMany(dbClient.Ctx, bson.D{{Key: "userId", Value: user.ID}})
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: security.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(conf.ResetTokenTTL),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = passwordResetTokensCollection(dbClient).InsertOne(dbClient.Ctx, resetToken)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	message := mailer.PasswordResetMessage(user.Email, user.Name, tokenLink(conf.ResetURL, token), conf.ResetTokenTTL)
+	if err := mailer.Send(message); err != nil {
+		fmt.Println("Error sending password reset email:", err)
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// tokenLink adds token to the query of link
+func tokenLink(link string, token string) string {
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+
+	return link + separator + "token=" + url.QueryEscape(token)
+}
+
+// ResetPassword sets a new password for the user a reset token was
+// emailed to. Tokens can only be used once, and the sessions of the
+// user are revoked since their password may have been compromised.
+func ResetPassword(dbClient *UsersClient, actor models.Actor, args models.ResetPasswordArgs) fiber.Error {
+	validationError := validators.ValidateResetPasswordArgs(args)
+	if validationError != nil {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	// Atomically mark the token as used, so that it
+	// can never be used by two concurrent requests
+	now := time.Now()
+	resetToken := models.PasswordResetToken{}
+	query := bson.D{
+		{Key: "tokenHash", Value: security.HashOpaqueToken(args.Token)},
+		{Key: "usedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "usedAt", Value: now}}}}
+
+	err := passwordResetTokensCollection(dbClient).FindOneAndUpdate(dbClient.Ctx, query, update).Decode(&resetToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_RESET_TOKEN}
+		}
+
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	id, err := primitive.ObjectIDFromHex(resetToken.UserID)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_INVALID_RESET_TOKEN}
+	}
+
+	if err := setPassword(dbClient, id, args.Password, false); (fiber.Error{}) != err {
+		return err
+	}
+
+	if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+		return revokeError
+	}
+
+	// The request is anonymous, the token tells who made it
+	if actor.ID == "" {
+		actor.ID = id.Hex()
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_PASSWORD_CHANGE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"server/models"
+	"server/security"
+	"server/validators"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var REFRESH_TOKENS_COLLECTION = "refresh_tokens"
+
+func refreshTokensCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(REFRESH_TOKENS_COLLECTION)
+}
+
+func issueRefreshToken(dbClient *UsersClient, userID string, family string) (string, fiber.Error) {
+	token, err := security.NewOpaqueToken()
+	if err != nil {
+		return "", fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	refreshToken := models.RefreshToken{
+		UserID:    userID,
+		Family:    family,
+		TokenHash: security.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(security.RefreshTokenTTL),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = refreshTokensCollection(dbClient).InsertOne(dbClient.Ctx, refreshToken)
+	if err != nil {
+		return "", fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return token, fiber.Error{}
+}
+
+// RefreshToken exchanges a refresh token for a new access token and
+// a new refresh token of the same family. A refresh token can only be
+// used once: presenting it again revokes its whole family.
+func RefreshToken(dbClient *UsersClient, args models.RefreshTokenArgs) (models.LoginResult, fiber.Error) {
+	result := models.LoginResult{}
+
+	validationError := validators.ValidateRefreshTokenArgs(args)
+	if validationError != nil {
+		return result, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	tokenHash := security.HashOpaqueToken(args.RefreshToken)
+	now := time.Now()
+
+	// Atomically mark the token as used, so that two concurrent
+	// requests can never both rotate it
+	refreshToken := models.RefreshToken{}
+	query := bson.D{
+		{Key: "tokenHash", Value: tokenHash},
+		{Key: "usedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "usedAt", Value: now}}},
+	}
+
+	err := refreshTokensCollection(dbClient).FindOneAndUpdate(dbClient.Ctx, query, update).Decode(&refreshToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// Either the token never existed, or it was already
+			// used or revoked. In the latter case it may have been
+			// stolen, so the whole family is revoked.
+			revokeReusedRefreshToken(dbClient, tokenHash)
+			return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_REFRESH}
+		}
+
+		return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if now.After(refreshToken.ExpiresAt) {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_REFRESH}
+	}
+
+	id, err := primitive.ObjectIDFromHex(refreshToken.UserID)
+	if err != nil {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_REFRESH}
+	}
+
+	user, userError := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != userError {
+		if userError.Code == fiber.StatusNotFound {
+			return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_REFRESH}
+		}
+
+		return result, userError
+	}
+
+	// Refresh tokens are rotated as long as their session goes on
+	session, sessionError := extendSession(dbClient, user.ID, refreshToken.Family)
+	if (fiber.Error{}) != sessionError {
+		return result, sessionError
+	}
+
+	return newLoginResult(dbClient, user, session)
+}
+
+func revokeReusedRefreshToken(dbClient *UsersClient, tokenHash string) {
+	refreshToken := models.RefreshToken{}
+	query := bson.D{{Key: "tokenHash", Value: tokenHash}}
+
+	if err := refreshTokensCollection(dbClient).FindOne(dbClient.Ctx, query).Decode(&refreshToken); err != nil {
+		return
+	}
+
+	RevokeRefreshTokenFamily(dbClient, refreshToken.Family)
+}
+
+func RevokeRefreshTokenFamily(dbClient *UsersClient, family string) fiber.Error {
+	query := bson.D{
+		{Key: "family", Value: family},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}},
+	}
+
+	_, err := refreshTokensCollection(dbClient).UpdateMany(dbClient.Ctx, query, update)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
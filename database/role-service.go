@@ -0,0 +1,406 @@
+package database
+
+import (
+	"fmt"
+	"server/models"
+	"server/security"
+	"server/validators"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ROLES_COLLECTION = "roles"
+
+func rolesCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(ROLES_COLLECTION)
+}
+
+// createDefaultRoles inserts the built-in roles that do not exist yet,
+// leaving the permissions of existing ones other than admin untouched
+func createDefaultRoles(dbClient *UsersClient) error {
+	for name, permissions := range security.DefaultRoles {
+		query := bson.D{{Key: "_id", Value: name}}
+		update := bson.D{
+			{Key: "$setOnInsert", Value: bson.D{{Key: "permissions", Value: permissions}}},
+		}
+
+		_, err := rolesCollection(dbClient).UpdateOne(dbClient.Ctx, query, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The admin role holds every permission, including
+	// the ones added since it was created
+	query := bson.D{{Key: "_id", Value: security.ROLE_ADMIN}}
+	update := bson.D{
+		{Key: "$addToSet", Value: bson.D{{Key: "permissions", Value: bson.D{{Key: "$each", Value: security.Permissions}}}}},
+	}
+
+	_, err := rolesCollection(dbClient).UpdateOne(dbClient.Ctx, query, update)
+	return err
+}
+
+// migrateIsAdminToRoles converts users stored with the former
+// isAdmin flag to the admin role. Only Mongo ever stored the flag.
+func migrateIsAdminToRoles(dbClient *UsersClient) error {
+	query := bson.D{{Key: "isAdmin", Value: true}}
+	update := bson.D{
+		{Key: "$addToSet", Value: bson.D{{Key: "roles", Value: security.ROLE_ADMIN}}},
+		{Key: "$unset", Value: bson.D{{Key: "isAdmin", Value: ""}}},
+	}
+
+	result, err := dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount > 0 {
+		fmt.Println("Migrated", result.ModifiedCount, "admins to the admin role")
+	}
+
+	// The remaining ones were not admins
+	query = bson.D{{Key: "isAdmin", Value: bson.D{{Key: "$exists", Value: true}}}}
+	update = bson.D{
+		{Key: "$set", Value: bson.D{{Key: "roles", Value: bson.A{}}}},
+		{Key: "$unset", Value: bson.D{{Key: "isAdmin", Value: ""}}},
+	}
+
+	_, err = dbClient.Col.UpdateMany(dbClient.Ctx, query, update)
+	return err
+}
+
+// ResolvePermissions returns the union of the permissions of roles
+func ResolvePermissions(dbClient *UsersClient, roles []string) ([]string, fiber.Error) {
+	permissions := make([]string, 0)
+	if len(roles) == 0 {
+		return permissions, fiber.Error{}
+	}
+
+	found, err := findRoles(dbClient, roles)
+	if (fiber.Error{}) != err {
+		return permissions, err
+	}
+
+	seen := map[string]bool{}
+	for _, role := range found {
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+
+	sort.Strings(permissions)
+	return permissions, fiber.Error{}
+}
+
+// CheckPermissionsCover makes sure permissions include every permission
+// of the user with the given id, so that nobody can take over an account
+// more powerful than theirs by changing its password or email
+func CheckPermissionsCover(dbClient *UsersClient, permissions []string, id primitive.ObjectID) fiber.Error {
+	user, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if err.Code == fiber.StatusNotFound {
+		// Left to the request to report
+		return fiber.Error{}
+	}
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	required, err := ResolvePermissions(dbClient, user.Roles)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	granted := map[string]bool{}
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+
+	for _, permission := range required {
+		if !granted[permission] {
+			return fiber.Error{Code: fiber.StatusForbidden, Message: ERROR_MESSAGE_TARGET_OUTRANKS}
+		}
+	}
+
+	return fiber.Error{}
+}
+
+// checkRolesExist makes sure users are only given defined roles
+func checkRolesExist(dbClient *UsersClient, roles []string) fiber.Error {
+	found, err := findRoles(dbClient, roles)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, role := range found {
+		existing[role.Name] = true
+	}
+
+	for _, role := range roles {
+		if !existing[role] {
+			return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_UNKNOWN_ROLE + ": " + role}
+		}
+	}
+
+	return fiber.Error{}
+}
+
+func findRoles(dbClient *UsersClient, names []string) ([]models.Role, fiber.Error) {
+	roles := make([]models.Role, 0)
+	if len(names) == 0 {
+		return roles, fiber.Error{}
+	}
+
+	query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: names}}}}
+
+	cursor, err := rolesCollection(dbClient).Find(dbClient.Ctx, query)
+	if err != nil {
+		return roles, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err = cursor.All(dbClient.Ctx, &roles); err != nil {
+		return roles, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return roles, fiber.Error{}
+}
+
+func GetRoles(dbClient *UsersClient) ([]models.Role, fiber.Error) {
+	roles := make([]models.Role, 0)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := rolesCollection(dbClient).Find(dbClient.Ctx, bson.D{}, opts)
+	if err != nil {
+		return roles, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err = cursor.All(dbClient.Ctx, &roles); err != nil {
+		return roles, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return roles, fiber.Error{}
+}
+
+// UpsertRole creates a role or replaces its permissions. Users holding
+// the role have to log in again to get the new permissions.
+func UpsertRole(dbClient *UsersClient, actor models.Actor, name string, args models.UpsertRoleArgs) (models.Role, fiber.Error) {
+	role := models.Role{Name: name, Permissions: args.Permissions}
+
+	validationError := validators.ValidateUpsertRoleArgs(args)
+	if validationError != nil {
+		return role, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	if role.Permissions == nil {
+		role.Permissions = make([]string, 0)
+	}
+
+	query := bson.D{{Key: "_id", Value: name}}
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+
+	// The role as it was, to record what changed
+	previous := models.Role{}
+	action := AUDIT_ACTION_ROLE_UPDATE
+	err := rolesCollection(dbClient).FindOneAndReplace(dbClient.Ctx, query, role, opts).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		action = AUDIT_ACTION_ROLE_CREATE
+	} else if err != nil {
+		return role, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if revokeError := revokeRoleHolderTokens(dbClient, name); (fiber.Error{}) != revokeError {
+		return role, revokeError
+	}
+
+	recordAuditEvent(dbClient, actor, action, name, diffRoles(previous, role))
+
+	return role, fiber.Error{}
+}
+
+// DeleteRole deletes a role and takes it away from every user holding it.
+// The admin role cannot be deleted, so that nobody gets locked out.
+func DeleteRole(dbClient *UsersClient, actor models.Actor, name string) fiber.Error {
+	if name == security.ROLE_ADMIN {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_ROLE_PROTECTED}
+	}
+
+	query := bson.D{{Key: "_id", Value: name}}
+
+	deleted := models.Role{Name: name}
+	err := rolesCollection(dbClient).FindOneAndDelete(dbClient.Ctx, query).Decode(&deleted)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_ROLE_NOT_FOUND}
+		}
+
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if revokeError := revokeRoleHolderTokens(dbClient, name); (fiber.Error{}) != revokeError {
+		return revokeError
+	}
+
+	holders, holdersError := roleHolders(dbClient, name)
+	if (fiber.Error{}) != holdersError {
+		return holdersError
+	}
+
+	for _, holder := range holders {
+		_, err := modifyUser(dbClient.Ctx, dbClient.Users, holder.ID, func(user *models.User) error {
+			user.Roles = removeRole(user.Roles, name)
+			return nil
+		})
+		if err != nil && err != ErrUserNotFound {
+			return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		}
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_ROLE_DELETE, name, diffRoles(deleted, models.Role{Name: name}))
+
+	return fiber.Error{}
+}
+
+// diffRoles returns the permissions of before and after when they differ,
+// roles that do not exist having none
+func diffRoles(before models.Role, after models.Role) map[string]models.FieldChange {
+	changes := map[string]models.FieldChange{}
+
+	if !sameRoles(before.Permissions, after.Permissions) {
+		changes["permissions"] = models.FieldChange{Before: nonNilRoles(before.Permissions), After: nonNilRoles(after.Permissions)}
+	}
+
+	return changes
+}
+
+// GrantRole gives a role to a user, who has to
+// log in again to get its permissions
+func GrantRole(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, name string) (models.User, fiber.Error) {
+	if rolesError := checkRolesExist(dbClient, []string{name}); (fiber.Error{}) != rolesError {
+		return models.User{}, rolesError
+	}
+
+	return changeRoles(dbClient, actor, id, func(roles []string) []string {
+		return append(removeRole(roles, name), name)
+	})
+}
+
+// RevokeRole takes a role away from a user, whose
+// tokens granting its permissions are revoked
+func RevokeRole(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, name string) (models.User, fiber.Error) {
+	return changeRoles(dbClient, actor, id, func(roles []string) []string {
+		return removeRole(roles, name)
+	})
+}
+
+func changeRoles(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, change func(roles []string) []string) (models.User, fiber.Error) {
+	previous := models.User{}
+	user, err := modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		previous = *user
+		roles := nonNilRoles(change(user.Roles))
+		if !sameRoles(user.Roles, roles) {
+			user.Roles = roles
+			user.UpdatedAt = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	if sameRoles(previous.Roles, user.Roles) {
+		return GetSafeUser(user), fiber.Error{}
+	}
+
+	if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+		return models.User{}, revokeError
+	}
+
+	changes := diffUsers(previous, user)
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_UPDATE, id.Hex(), changes)
+
+	return GetSafeUser(user), fiber.Error{}
+}
+
+func revokeRoleHolderTokens(dbClient *UsersClient, name string) fiber.Error {
+	holders, err := roleHolders(dbClient, name)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	for _, user := range holders {
+		if revokeError := RevokeUserTokens(dbClient, user.ID); (fiber.Error{}) != revokeError {
+			return revokeError
+		}
+	}
+
+	return fiber.Error{}
+}
+
+// roleHolders lists the users holding the role name,
+// soft deleted ones included since they can be restored
+func roleHolders(dbClient *UsersClient, name string) ([]models.User, fiber.Error) {
+	holders := []models.User{}
+	collect := func(user models.User) error {
+		holders = append(holders, user)
+		return nil
+	}
+
+	for _, deleted := range []bool{false, true} {
+		query := UserListQuery{GetAllArgs: models.GetAllArgs{Role: name}, Deleted: deleted}
+		if err := eachUser(dbClient.Ctx, dbClient.Users, query, collect); err != nil {
+			return holders, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		}
+	}
+
+	return holders, fiber.Error{}
+}
+
+func removeRole(roles []string, name string) []string {
+	remaining := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role != name {
+			remaining = append(remaining, role)
+		}
+	}
+
+	return remaining
+}
+
+// nonNilRoles makes sure roles are stored as an array, never as null
+func nonNilRoles(roles []string) []string {
+	if roles == nil {
+		return make([]string, 0)
+	}
+
+	return roles
+}
+
+func sameRoles(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := map[string]int{}
+	for _, role := range a {
+		counts[role]++
+	}
+
+	for _, role := range b {
+		counts[role]--
+		if counts[role] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
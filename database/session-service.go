@@ -0,0 +1,203 @@
+package database
+
+import (
+	"server/models"
+	"server/security"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var SESSIONS_COLLECTION = "sessions"
+
+// The last-seen time of a session is only updated this often,
+// rather than on every request
+var SESSION_TOUCH_INTERVAL = time.Minute
+
+func sessionsCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(SESSIONS_COLLECTION)
+}
+
+// activeSessionQuery matches the session sid of userID
+// unless it was revoked or has expired
+func activeSessionQuery(userID string, sid string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: sid},
+		{Key: "userId", Value: userID},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+}
+
+// openSession records a new login of userID, from the
+// device and IP the actor of the request is on
+func openSession(dbClient *UsersClient, userID string, actor models.Actor) (models.Session, fiber.Error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         primitive.NewObjectID().Hex(),
+		UserID:     userID,
+		UserAgent:  actor.UserAgent,
+		IP:         actor.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(security.RefreshTokenTTL),
+	}
+
+	if _, err := sessionsCollection(dbClient).InsertOne(dbClient.Ctx, session); err != nil {
+		return session, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return session, fiber.Error{}
+}
+
+// extendSession keeps a session going for another refresh token
+// lifetime, as its refresh token is rotated
+func extendSession(dbClient *UsersClient, userID string, sid string) (models.Session, fiber.Error) {
+	session := models.Session{}
+	now := time.Now()
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "lastSeenAt", Value: now},
+		{Key: "expiresAt", Value: now.Add(security.RefreshTokenTTL)},
+	}}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := sessionsCollection(dbClient).FindOneAndUpdate(dbClient.Ctx, activeSessionQuery(userID, sid), update, opts).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return session, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_SESSION_ENDED}
+		}
+
+		return session, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return session, fiber.Error{}
+}
+
+// IsSessionActive reports whether the session the claims belong to
+// is still going, and records that it was just seen. Tokens issued
+// outside of any session, like restricted ones, have no session to check.
+func IsSessionActive(dbClient *UsersClient, claims *security.MyCustomClaims) (bool, fiber.Error) {
+	if claims.SessionID == "" {
+		return true, fiber.Error{}
+	}
+
+	session := models.Session{}
+	err := sessionsCollection(dbClient).FindOne(dbClient.Ctx, activeSessionQuery(claims.Subject, claims.SessionID)).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, fiber.Error{}
+		}
+
+		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= SESSION_TOUCH_INTERVAL {
+		query := bson.D{{Key: "_id", Value: session.ID}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "lastSeenAt", Value: now}}}}
+
+		if _, err := sessionsCollection(dbClient).UpdateOne(dbClient.Ctx, query, update); err != nil {
+			return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		}
+	}
+
+	return true, fiber.Error{}
+}
+
+// GetSessions lists the active sessions of a user, last seen first,
+// flagging currentSID as the session of the request
+func GetSessions(dbClient *UsersClient, id primitive.ObjectID, currentSID string) ([]models.Session, fiber.Error) {
+	sessions := []models.Session{}
+
+	if _, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex()); (fiber.Error{}) != err {
+		return sessions, err
+	}
+
+	query := bson.D{
+		{Key: "userId", Value: id.Hex()},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}})
+
+	cursor, err := sessionsCollection(dbClient).Find(dbClient.Ctx, query, opts)
+	if err != nil {
+		return sessions, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err = cursor.All(dbClient.Ctx, &sessions); err != nil {
+		return sessions, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSID
+	}
+
+	return sessions, fiber.Error{}
+}
+
+// RevokeSession signs a user out of one of their sessions. The access
+// tokens of the session are refused from then on, and its refresh
+// tokens revoked.
+func RevokeSession(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, sid string) fiber.Error {
+	if err := revokeSession(dbClient, id.Hex(), sid); (fiber.Error{}) != err {
+		return err
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_SESSION_REVOKE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
+
+func revokeSession(dbClient *UsersClient, userID string, sid string) fiber.Error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}}}
+
+	result, err := sessionsCollection(dbClient).UpdateOne(dbClient.Ctx, activeSessionQuery(userID, sid), update)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if result.MatchedCount == 0 {
+		return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_SESSION_NOT_FOUND}
+	}
+
+	return RevokeRefreshTokenFamily(dbClient, sid)
+}
+
+// SignOutEverywhere ends every session of a user, and
+// revokes every token issued to them so far
+func SignOutEverywhere(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) fiber.Error {
+	if _, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex()); (fiber.Error{}) != err {
+		return err
+	}
+
+	if err := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != err {
+		return err
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_SIGN_OUT_EVERYWHERE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
+
+// revokeUserSessions ends the sessions of a user but sparedSID, if set
+func revokeUserSessions(dbClient *UsersClient, userID string, sparedSID string) fiber.Error {
+	query := bson.D{
+		{Key: "userId", Value: userID},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	if sparedSID != "" {
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$ne", Value: sparedSID}}})
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}}}
+
+	if _, err := sessionsCollection(dbClient).UpdateMany(dbClient.Ctx, query, update); err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/config"
+	"server/models"
+	"server/security"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var SIGNING_KEYS_COLLECTION = "signing_keys"
+
+func signingKeysCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(SIGNING_KEYS_COLLECTION)
+}
+
+// LoadSigningKeys makes every stored, unexpired key available
+// to sign and verify tokens
+func LoadSigningKeys(dbClient *UsersClient) error {
+	query := bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}}}
+
+	var records []models.SigningKey
+	cursor, err := signingKeysCollection(dbClient).Find(dbClient.Ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if err = cursor.All(dbClient.Ctx, &records); err != nil {
+		return err
+	}
+
+	keys := make([]security.SigningKey, 0, len(records))
+	for _, record := range records {
+		privateKey, err := security.DecodePrivateKey(record.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.Kid, err)
+		}
+
+		keys = append(keys, security.SigningKey{
+			Kid:        record.Kid,
+			Algorithm:  record.Algorithm,
+			PrivateKey: privateKey,
+			CreatedAt:  record.CreatedAt,
+			ExpiresAt:  record.ExpiresAt,
+		})
+	}
+
+	security.SetSigningKeys(keys)
+	return nil
+}
+
+// RotateSigningKeys generates a new signing key when the newest one
+// is older than the rotation interval, or uses another algorithm than
+// the configured one, then reloads the keys.
+func RotateSigningKeys(dbClient *UsersClient) error {
+	conf := config.GetConfig()
+
+	// Expired keys count too, so that a key succeeding
+	// one that expired is only created once as well
+	newest := models.SigningKey{}
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	err := signingKeysCollection(dbClient).FindOne(dbClient.Ctx, bson.D{}, opts).Decode(&newest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	due := err == mongo.ErrNoDocuments ||
+		!newest.ExpiresAt.After(time.Now()) ||
+		newest.Algorithm != conf.Jwt.Algorithm ||
+		time.Since(newest.CreatedAt) >= conf.Jwt.RotationInterval
+
+	if due {
+		if err := createSigningKey(dbClient, conf, newest.Kid); err != nil {
+			return err
+		}
+	}
+
+	return LoadSigningKeys(dbClient)
+}
+
+// createSigningKey stores a new key succeeding the key replaces, unless
+// another replica just did, which the unique index on replaces tells
+func createSigningKey(dbClient *UsersClient, conf config.Configuration, replaces string) error {
+	key, err := security.GenerateSigningKey(conf.Jwt.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := security.EncodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	// A retired key keeps verifying the tokens it signed until they
+	// have all expired. It signs until its successor is published,
+	// up to a refresh interval after it is created and another one
+	// after every replica reloaded the keys.
+	record := models.SigningKey{
+		Kid:        key.Kid,
+		Algorithm:  key.Algorithm,
+		PrivateKey: privateKey,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.CreatedAt.Add(conf.Jwt.RotationInterval + 3*conf.Jwt.RefreshInterval + longestSignedTokenTTL(conf)),
+		Replaces:   replaces,
+	}
+
+	_, err = signingKeysCollection(dbClient).InsertOne(dbClient.Ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err == nil {
+		fmt.Println("Created signing key", record.Kid)
+	}
+
+	return err
+}
+
+// longestSignedTokenTTL is the lifetime of the
+// longest-lived tokens signed with the signing keys
+func longestSignedTokenTTL(conf config.Configuration) time.Duration {
+	ttl := conf.Auth.AccessTokenTTL
+	for _, other := range []time.Duration{conf.Auth.MfaTokenTTL, conf.Auth.PasswordChangeTokenTTL} {
+		if other > ttl {
+			ttl = other
+		}
+	}
+
+	return ttl
+}
+
+// StartSigningKeyRotation periodically reloads the signing keys, so that
+// keys created by other replicas are picked up, and rotates them when due
+func StartSigningKeyRotation(dbClient *UsersClient) {
+	interval := config.GetConfig().Jwt.RefreshInterval
+	if interval <= 0 {
+		panic(errors.New("jwt.refreshInterval must be positive"))
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := RotateSigningKeys(dbClient); err != nil {
+				fmt.Println("Error rotating signing keys:", err)
+			}
+		}
+	}()
+}
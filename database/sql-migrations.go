@@ -0,0 +1,348 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLDialect holds what differs between the SQL databases users can be
+// stored in. Statements are written in the SQL both of them understand.
+type SQLDialect struct {
+	// DriverName is the database/sql driver of the database
+	DriverName string
+	// Placeholder returns the nth parameter of a statement, from 1
+	Placeholder func(n int) string
+	// LockMigrations, when set, is run first in every migration so that
+	// replicas starting together apply them one at a time
+	LockMigrations string
+	// IsUniqueViolation tells whether err comes from a unique index
+	IsUniqueViolation func(err error) bool
+	// MaxOpenConns limits the connections to the database when set
+	MaxOpenConns int
+}
+
+// SQL_DIALECTS are the SQL databases users can be stored in, by name
+// of storage driver. SQLite needs the server built with cgo.
+var SQL_DIALECTS = map[string]SQLDialect{
+	"postgres": {
+		DriverName:  "postgres",
+		Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		// An arbitrary key, the same for every replica
+		LockMigrations: "SELECT pg_advisory_xact_lock(7243050431)",
+		IsUniqueViolation: func(err error) bool {
+			var pqError *pq.Error
+			return errors.As(err, &pqError) && pqError.Code == "23505"
+		},
+	},
+	"sqlite": {
+		DriverName:  "sqlite3",
+		Placeholder: func(n int) string { return "?" },
+		// Writers take turns on the file
+		MaxOpenConns: 1,
+		// The error type of the driver only exists when built with cgo
+		IsUniqueViolation: func(err error) bool {
+			return strings.Contains(err.Error(), "UNIQUE constraint failed")
+		},
+	},
+}
+
+// SQLMigration changes the schema from the previous version to Version
+// when applied, and back when reverted. Its statements run in a single
+// transaction, along with the bookkeeping of schema_migrations.
+type SQLMigration struct {
+	Version int
+	Name    string
+	Up      func(dialect SQLDialect) []string
+	Down    func(dialect SQLDialect) []string
+}
+
+// SQL_MIGRATIONS are the versions of the schema, oldest first.
+// Applied migrations must never change: fixes go in new ones.
+var SQL_MIGRATIONS = []SQLMigration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: func(dialect SQLDialect) []string {
+			return []string{
+				// Dates are Unix milliseconds, the precision of BSON dates,
+				// null when unset. The password history and recovery codes
+				// are JSON arrays of hashes, only read and written whole.
+				`CREATE TABLE users (
+					id TEXT PRIMARY KEY,
+					revision BIGINT NOT NULL,
+					email TEXT NOT NULL,
+					name TEXT NOT NULL,
+					title TEXT NOT NULL,
+					birthdate BIGINT,
+					password TEXT NOT NULL,
+					status TEXT NOT NULL,
+					created_at BIGINT NOT NULL,
+					updated_at BIGINT NOT NULL,
+					deleted_at BIGINT,
+					email_verified BOOLEAN NOT NULL,
+					email_verified_at BIGINT,
+					pending_email TEXT NOT NULL,
+					must_change_password BOOLEAN NOT NULL,
+					password_changed_at BIGINT,
+					password_expiry_reminder_at BIGINT,
+					password_history TEXT NOT NULL,
+					failed_login_count INTEGER NOT NULL,
+					last_failed_login_at BIGINT,
+					locked_until BIGINT,
+					invitation_expires_at BIGINT,
+					two_factor_enabled BOOLEAN NOT NULL,
+					two_factor_secret TEXT NOT NULL,
+					two_factor_pending_secret TEXT NOT NULL,
+					two_factor_last_step BIGINT NOT NULL,
+					recovery_codes TEXT NOT NULL
+				)`,
+				// Emails are unique whatever their case
+				`CREATE UNIQUE INDEX users_lower_email_key ON users (lower(email))`,
+				// Listings can be sorted on the following fields,
+				// id being the tie-breaker of paginated listings
+				`CREATE INDEX users_email_idx ON users (email, id)`,
+				`CREATE INDEX users_name_idx ON users (name, id)`,
+				`CREATE INDEX users_title_idx ON users (title, id)`,
+				`CREATE INDEX users_created_at_idx ON users (created_at, id)`,
+				`CREATE INDEX users_updated_at_idx ON users (updated_at, id)`,
+				`CREATE INDEX users_deleted_at_idx ON users (deleted_at)`,
+				// Roles keep their order by position. Users are
+				// looked up by role when a role changes.
+				`CREATE TABLE user_roles (
+					user_id TEXT NOT NULL,
+					role TEXT NOT NULL,
+					position INTEGER NOT NULL,
+					PRIMARY KEY (user_id, role)
+				)`,
+				`CREATE INDEX user_roles_role_idx ON user_roles (role)`,
+			}
+		},
+		Down: func(dialect SQLDialect) []string {
+			return []string{
+				`DROP TABLE user_roles`,
+				`DROP TABLE users`,
+			}
+		},
+	},
+	{
+		Version: 2,
+		Name:    "restrict_email_index_to_undeleted_users",
+		Up: func(dialect SQLDialect) []string {
+			return []string{
+				// Deleting a user frees their email
+				`DROP INDEX users_lower_email_key`,
+				`CREATE UNIQUE INDEX users_lower_email_undeleted_key ON users (lower(email)) WHERE deleted_at IS NULL`,
+			}
+		},
+		// Fails when deleted users share their email with another user
+		Down: func(dialect SQLDialect) []string {
+			return []string{
+				`DROP INDEX users_lower_email_undeleted_key`,
+				`CREATE UNIQUE INDEX users_lower_email_key ON users (lower(email))`,
+			}
+		},
+	},
+}
+
+// AppliedSQLMigration is a migration recorded in schema_migrations
+type AppliedSQLMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func createSchemaMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at BIGINT NOT NULL
+	)`)
+	return err
+}
+
+// AppliedSQLMigrations lists the migrations applied to db, oldest first
+func AppliedSQLMigrations(ctx context.Context, db *sql.DB) ([]AppliedSQLMigration, error) {
+	applied := []AppliedSQLMigration{}
+
+	if err := createSchemaMigrationsTable(ctx, db); err != nil {
+		return applied, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return applied, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		migration := AppliedSQLMigration{}
+		var appliedAt int64
+		if err := rows.Scan(&migration.Version, &migration.Name, &appliedAt); err != nil {
+			return applied, err
+		}
+
+		migration.AppliedAt = fromUnixMillis(appliedAt)
+		applied = append(applied, migration)
+	}
+
+	return applied, rows.Err()
+}
+
+// PendingSQLMigrations lists the migrations db is missing, in order
+func PendingSQLMigrations(ctx context.Context, db *sql.DB) ([]SQLMigration, error) {
+	pending := []SQLMigration{}
+
+	applied, err := AppliedSQLMigrations(ctx, db)
+	if err != nil {
+		return pending, err
+	}
+
+	done := map[int]bool{}
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	for _, migration := range sortedSQLMigrations() {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// MigrateSQLUp applies the migrations db is missing, in order, and
+// returns the ones it applied, or would apply when dryRun is set
+func MigrateSQLUp(ctx context.Context, db *sql.DB, dialect SQLDialect, dryRun bool) ([]SQLMigration, error) {
+	migrated := []SQLMigration{}
+
+	pending, err := PendingSQLMigrations(ctx, db)
+	if err != nil || dryRun {
+		return pending, err
+	}
+
+	for _, migration := range pending {
+		ran, err := runSQLMigration(ctx, db, dialect, migration, true)
+		if err != nil {
+			return migrated, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		if ran {
+			migrated = append(migrated, migration)
+		}
+	}
+
+	return migrated, nil
+}
+
+// MigrateSQLDown reverts the last steps migrations applied to db, newest
+// first, and returns the ones it reverted, or would revert when dryRun is set
+func MigrateSQLDown(ctx context.Context, db *sql.DB, dialect SQLDialect, steps int, dryRun bool) ([]SQLMigration, error) {
+	reverted := []SQLMigration{}
+
+	applied, err := AppliedSQLMigrations(ctx, db)
+	if err != nil {
+		return reverted, err
+	}
+
+	known := map[int]SQLMigration{}
+	for _, migration := range SQL_MIGRATIONS {
+		known[migration.Version] = migration
+	}
+
+	for i := len(applied) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration, ok := known[applied[i].Version]
+		if !ok {
+			return reverted, fmt.Errorf("migration %d %s is unknown to this version", applied[i].Version, applied[i].Name)
+		}
+
+		if dryRun {
+			reverted = append(reverted, migration)
+			continue
+		}
+
+		ran, err := runSQLMigration(ctx, db, dialect, migration, false)
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		if ran {
+			reverted = append(reverted, migration)
+		}
+	}
+
+	return reverted, nil
+}
+
+// runSQLMigration applies or reverts migration in a transaction, unless
+// another replica did it meanwhile, in which case it reports not running
+func runSQLMigration(ctx context.Context, db *sql.DB, dialect SQLDialect, migration SQLMigration, up bool) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if dialect.LockMigrations != "" {
+		if _, err := tx.ExecContext(ctx, dialect.LockMigrations); err != nil {
+			return false, err
+		}
+	}
+
+	var count int
+	query := `SELECT COUNT(*) FROM schema_migrations WHERE version = ` + dialect.Placeholder(1)
+	if err := tx.QueryRowContext(ctx, query, migration.Version).Scan(&count); err != nil {
+		return false, err
+	}
+	if (count > 0) == up {
+		return false, nil
+	}
+
+	statements := migration.Down(dialect)
+	if up {
+		statements = migration.Up(dialect)
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES (`+
+				dialect.Placeholder(1)+`, `+dialect.Placeholder(2)+`, `+dialect.Placeholder(3)+`)`,
+			migration.Version, migration.Name, unixMillis(time.Now()))
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = `+dialect.Placeholder(1), migration.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func sortedSQLMigrations() []SQLMigration {
+	migrations := append([]SQLMigration{}, SQL_MIGRATIONS...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations
+}
+
+// fromUnixMillis turns Unix milliseconds into a UTC time,
+// the way dates are read from BSON
+func fromUnixMillis(millis int64) time.Time {
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"server/models"
+	"server/security"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var REVOKED_TOKENS_COLLECTION = "revoked_tokens"
+
+func revokedTokensCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(REVOKED_TOKENS_COLLECTION)
+}
+
+func tokenRevocationId(jti string) string {
+	return "jti:" + jti
+}
+
+func userRevocationId(userID string) string {
+	return "user:" + userID
+}
+
+// RevokeToken revokes a single access token until it expires
+func RevokeToken(dbClient *UsersClient, claims *security.MyCustomClaims) fiber.Error {
+	revocation := models.TokenRevocation{
+		ID:        tokenRevocationId(claims.Id),
+		UserID:    claims.Subject,
+		Jti:       claims.Id,
+		RevokedAt: time.Now(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+
+	query := bson.D{{Key: "_id", Value: revocation.ID}}
+
+	_, err := revokedTokensCollection(dbClient).ReplaceOne(dbClient.Ctx, query, revocation, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// RevokeUserTokens revokes every access and refresh token
+// issued to a user so far, ending all their sessions
+func RevokeUserTokens(dbClient *UsersClient, userID string) fiber.Error {
+	return revokeUserTokens(dbClient, userID, "")
+}
+
+// RevokeOtherUserTokens revokes the tokens of a user like RevokeUserTokens,
+// but for those of the session sid, which keeps going
+func RevokeOtherUserTokens(dbClient *UsersClient, userID string, sid string) fiber.Error {
+	return revokeUserTokens(dbClient, userID, sid)
+}
+
+func revokeUserTokens(dbClient *UsersClient, userID string, sparedSID string) fiber.Error {
+	// Tokens issued before now, restricted ones included, are
+	// all expired once the longest token lifetime has passed
+	revocation := models.TokenRevocation{
+		ID:              userRevocationId(userID),
+		UserID:          userID,
+		RevokedAt:       time.Now(),
+		SparedSessionID: sparedSID,
+		ExpiresAt:       time.Now().Add(security.MaxSignedTokenTTL()),
+	}
+
+	query := bson.D{{Key: "_id", Value: revocation.ID}}
+
+	_, err := revokedTokensCollection(dbClient).ReplaceOne(dbClient.Ctx, query, revocation, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	refreshQuery := bson.D{
+		{Key: "userId", Value: userID},
+		{Key: "revokedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	if sparedSID != "" {
+		refreshQuery = append(refreshQuery, bson.E{Key: "family", Value: bson.D{{Key: "$ne", Value: sparedSID}}})
+	}
+	refreshUpdate := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now()}}},
+	}
+
+	_, err = refreshTokensCollection(dbClient).UpdateMany(dbClient.Ctx, refreshQuery, refreshUpdate)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return revokeUserSessions(dbClient, userID, sparedSID)
+}
+
+// IsTokenRevoked reports whether the token the claims belong to
+// was revoked on its own, or along with every token of its user
+func IsTokenRevoked(dbClient *UsersClient, claims *security.MyCustomClaims) (bool, fiber.Error) {
+	query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{
+		tokenRevocationId(claims.Id),
+		userRevocationId(claims.Subject),
+	}}}}}
+
+	var revocations []models.TokenRevocation
+	cursor, err := revokedTokensCollection(dbClient).Find(dbClient.Ctx, query)
+	if err != nil {
+		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if err = cursor.All(dbClient.Ctx, &revocations); err != nil {
+		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	for _, revocation := range revocations {
+		if revocation.Jti != "" {
+			return true, fiber.Error{}
+		}
+
+		spared := revocation.SparedSessionID != "" && revocation.SparedSessionID == claims.SessionID
+		if !spared && issuedBefore(claims, revocation.RevokedAt) {
+			return true, fiber.Error{}
+		}
+	}
+
+	return false, fiber.Error{}
+}
+
+// issuedBefore tells whether the token of claims was issued before t,
+// to the millisecond, which is as precise as the dates Mongo stores.
+// Tokens issued before they carried iatms are compared to the second,
+// those of the same second counting as issued before.
+func issuedBefore(claims *security.MyCustomClaims, t time.Time) bool {
+	if claims.IssuedAtMillis != 0 {
+		return claims.IssuedAtMillis < unixMillis(t)
+	}
+
+	return claims.IssuedAt <= t.Unix()
+}
+
+// unixMillis turns t into Unix milliseconds, the precision of BSON dates
+func unixMillis(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond())/int64(time.Millisecond)
+}
+
+// Logout revokes the access token in use and ends its session, along
+// with, when provided, the refresh token family it came with
+func Logout(dbClient *UsersClient, claims *security.MyCustomClaims, args models.LogoutArgs) fiber.Error {
+	err := RevokeToken(dbClient, claims)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	if claims.SessionID != "" {
+		err = revokeSession(dbClient, claims.Subject, claims.SessionID)
+		if (fiber.Error{}) != err && err.Code != fiber.StatusNotFound {
+			return err
+		}
+	}
+
+	if args.RefreshToken == "" {
+		return fiber.Error{}
+	}
+
+	refreshToken := models.RefreshToken{}
+	query := bson.D{
+		{Key: "tokenHash", Value: security.HashOpaqueToken(args.RefreshToken)},
+		{Key: "userId", Value: claims.Subject},
+	}
+
+	if err := refreshTokensCollection(dbClient).FindOne(dbClient.Ctx, query).Decode(&refreshToken); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fiber.Error{}
+		}
+
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return RevokeRefreshTokenFamily(dbClient, refreshToken.Family)
+}
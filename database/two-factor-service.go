@@ -0,0 +1,311 @@
+package database
+
+import (
+	"errors"
+	"server/config"
+	"server/models"
+	"server/security"
+	"server/validators"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var MFA_ATTEMPTS_COLLECTION = "mfa_attempts"
+
+func mfaAttemptsCollection(dbClient *UsersClient) *mongo.Collection {
+	return dbClient.DB.Collection(MFA_ATTEMPTS_COLLECTION)
+}
+
+func newMfaLoginResult(user models.User) (models.LoginResult, fiber.Error) {
+	result := models.LoginResult{}
+
+	token, err := security.NewMfaToken(&user)
+	if err != nil {
+		return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	result.MfaRequired = true
+	result.MfaToken = token
+	return result, fiber.Error{}
+}
+
+// LoginWithMfa completes a login started with a correct password
+// on an account with two-factor authentication enabled
+func LoginWithMfa(dbClient *UsersClient, actor models.Actor, args models.MfaLoginArgs) (models.LoginResult, fiber.Error) {
+	result := models.LoginResult{}
+
+	validationError := validators.ValidateMfaLoginArgs(args)
+	if validationError != nil {
+		return result, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	claims, err := security.ParseToken(args.MfaToken)
+	if err != nil || claims.Scope != security.SCOPE_MFA_PENDING {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_LOGIN_FAILED}
+	}
+
+	// The pending token can only be exchanged once
+	revoked, revocationError := IsTokenRevoked(dbClient, claims)
+	if (fiber.Error{}) != revocationError {
+		return result, revocationError
+	}
+	if revoked {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_LOGIN_FAILED}
+	}
+
+	user, userError := getTwoFactorUser(dbClient, claims.Subject)
+	if (fiber.Error{}) != userError {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_LOGIN_FAILED}
+	}
+
+	verifyError := verifySecondFactor(dbClient, user, args.Code, args.RecoveryCode)
+	if verifyError.Code == fiber.StatusUnauthorized {
+		if failureError := recordMfaTokenFailure(dbClient, claims); (fiber.Error{}) != failureError {
+			return result, failureError
+		}
+	}
+	if (fiber.Error{}) != verifyError {
+		return result, verifyError
+	}
+
+	if revokeError := RevokeToken(dbClient, claims); (fiber.Error{}) != revokeError {
+		return result, revokeError
+	}
+
+	return newLoginResult(dbClient, user, models.Session{UserAgent: actor.UserAgent, IP: actor.IP})
+}
+
+// EnrollTwoFactor generates a new TOTP secret for the user. It only
+// takes effect once confirmed with a code through ConfirmTwoFactor.
+func EnrollTwoFactor(dbClient *UsersClient, id primitive.ObjectID) (models.TwoFactorEnrollment, fiber.Error) {
+	enrollment := models.TwoFactorEnrollment{}
+
+	user, err := getTwoFactorUser(dbClient, id.Hex())
+	if (fiber.Error{}) != err {
+		return enrollment, err
+	}
+
+	if user.TwoFactorEnabled {
+		return enrollment, fiber.Error{Code: fiber.StatusConflict, Message: ERROR_MESSAGE_MFA_ALREADY_ENABLED}
+	}
+
+	secret, secretError := security.NewTOTPSecret()
+	if secretError != nil {
+		return enrollment, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	err = updateTwoFactorFields(dbClient, id, func(user *models.User) {
+		user.TwoFactorPendingSecret = secret
+		user.UpdatedAt = time.Now()
+	})
+	if (fiber.Error{}) != err {
+		return enrollment, err
+	}
+
+	enrollment.Secret = secret
+	enrollment.OtpauthURI = security.TOTPURI(config.GetConfig().Auth.MfaIssuer, user.Email, secret)
+	return enrollment, fiber.Error{}
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves
+// their authenticator works, and returns their recovery codes. Those are
+// only stored hashed, so this is the only time they can be shown.
+func ConfirmTwoFactor(dbClient *UsersClient, id primitive.ObjectID, args models.TwoFactorCodeArgs) (models.RecoveryCodes, fiber.Error) {
+	result := models.RecoveryCodes{}
+
+	validationError := validators.ValidateTwoFactorCodeArgs(args)
+	if validationError != nil {
+		return result, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	user, err := getTwoFactorUser(dbClient, id.Hex())
+	if (fiber.Error{}) != err {
+		return result, err
+	}
+
+	if user.TwoFactorEnabled {
+		return result, fiber.Error{Code: fiber.StatusConflict, Message: ERROR_MESSAGE_MFA_ALREADY_ENABLED}
+	}
+
+	if user.TwoFactorPendingSecret == "" {
+		return result, fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_MFA_NOT_ENROLLED}
+	}
+
+	step, ok := security.ValidateTOTP(user.TwoFactorPendingSecret, args.Code, time.Now())
+	if !ok {
+		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_MFA_CODE}
+	}
+
+	codes, codesError := security.NewRecoveryCodes()
+	if codesError != nil {
+		return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	hashedCodes := make([]string, len(codes))
+	for i, code := range codes {
+		hashedCodes[i] = security.HashRecoveryCode(code)
+	}
+
+	err = updateTwoFactorFields(dbClient, id, func(user *models.User) {
+		user.TwoFactorEnabled = true
+		user.TwoFactorSecret = user.TwoFactorPendingSecret
+		user.TwoFactorLastStep = step
+		user.RecoveryCodes = hashedCodes
+		user.TwoFactorPendingSecret = ""
+		user.UpdatedAt = time.Now()
+	})
+	if (fiber.Error{}) != err {
+		return result, err
+	}
+
+	result.RecoveryCodes = codes
+	return result, fiber.Error{}
+}
+
+// DisableTwoFactor turns two-factor authentication off,
+// provided a valid TOTP or recovery code
+func DisableTwoFactor(dbClient *UsersClient, id primitive.ObjectID, args models.SecondFactorArgs) fiber.Error {
+	validationError := validators.ValidateSecondFactorArgs(args)
+	if validationError != nil {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	user, err := getTwoFactorUser(dbClient, id.Hex())
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	if !user.TwoFactorEnabled {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_MFA_NOT_ENABLED}
+	}
+
+	err = verifySecondFactor(dbClient, user, args.Code, args.RecoveryCode)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	return updateTwoFactorFields(dbClient, id, func(user *models.User) {
+		user.TwoFactorEnabled = false
+		user.TwoFactorSecret = ""
+		user.TwoFactorLastStep = 0
+		user.RecoveryCodes = nil
+		user.UpdatedAt = time.Now()
+	})
+}
+
+// recordMfaTokenFailure counts a wrong second factor given with the
+// pending token of claims, which is revoked after too many of them
+func recordMfaTokenFailure(dbClient *UsersClient, claims *security.MyCustomClaims) fiber.Error {
+	query := bson.D{{Key: "_id", Value: claims.Id}}
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: time.Unix(claims.ExpiresAt, 0)}}},
+	}
+
+	attempts := struct {
+		Failures int `bson:"failures"`
+	}{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(true)
+	err := mfaAttemptsCollection(dbClient).FindOneAndUpdate(dbClient.Ctx, query, update, opts).Decode(&attempts)
+	if err != nil {
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	if attempts.Failures < config.GetConfig().Lockout.MaxMfaTokenFailures {
+		return fiber.Error{}
+	}
+
+	return RevokeToken(dbClient, claims)
+}
+
+// verifySecondFactor accepts either a TOTP code, which cannot be replayed
+// within its time step, or a recovery code, which is consumed. Wrong ones
+// count as failed logins of the account, refused while it is locked out.
+// Like passwords, they are counted beforehand and taken back when right.
+func verifySecondFactor(dbClient *UsersClient, user models.User, code string, recoveryCode string) fiber.Error {
+	user, _, err := countAccountLoginAttempt(dbClient, user.ID)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	err = consumeSecondFactor(dbClient, user, code, recoveryCode)
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	return clearLoginFailures(dbClient, user)
+}
+
+func consumeSecondFactor(dbClient *UsersClient, user models.User, code string, recoveryCode string) fiber.Error {
+	var consume func(user *models.User) error
+
+	if code != "" {
+		step, ok := security.ValidateTOTP(user.TwoFactorSecret, code, time.Now())
+		if !ok {
+			return fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_MFA_CODE}
+		}
+
+		consume = func(user *models.User) error {
+			if user.TwoFactorLastStep >= step {
+				return errInvalidSecondFactor
+			}
+
+			user.TwoFactorLastStep = step
+			return nil
+		}
+	} else {
+		hashedCode := security.HashRecoveryCode(recoveryCode)
+
+		consume = func(user *models.User) error {
+			remaining := make([]string, 0, len(user.RecoveryCodes))
+			for _, candidate := range user.RecoveryCodes {
+				if candidate != hashedCode {
+					remaining = append(remaining, candidate)
+				}
+			}
+
+			if len(remaining) == len(user.RecoveryCodes) {
+				return errInvalidSecondFactor
+			}
+
+			user.RecoveryCodes = remaining
+			return nil
+		}
+	}
+
+	_, err := modifyUser(dbClient.Ctx, dbClient.Users, user.ID, consume)
+	if err != nil {
+		if err == errInvalidSecondFactor {
+			return fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_INVALID_MFA_CODE}
+		}
+
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	return fiber.Error{}
+}
+
+// errInvalidSecondFactor aborts consuming a code that was already used
+var errInvalidSecondFactor = errors.New("invalid second factor")
+
+// getTwoFactorUser returns the full user document, secrets included
+func getTwoFactorUser(dbClient *UsersClient, userID string) (models.User, fiber.Error) {
+	return getActiveUser(dbClient.Ctx, dbClient.Users, userID)
+}
+
+func updateTwoFactorFields(dbClient *UsersClient, id primitive.ObjectID, change func(user *models.User)) fiber.Error {
+	_, err := modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		change(user)
+		return nil
+	})
+	if err != nil {
+		return userRepositoryError(err)
+	}
+
+	return fiber.Error{}
+}
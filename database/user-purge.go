@@ -0,0 +1,97 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"server/config"
+	"server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PurgeDeletedUsers permanently removes the users soft deleted
+// longer than the retention period ago, along with their records
+func PurgeDeletedUsers(dbClient *UsersClient, retention time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-retention)
+
+	expired := []string{}
+	err := eachUser(dbClient.Ctx, dbClient.Users, UserListQuery{Deleted: true}, func(user models.User) error {
+		if user.DeletedAt.Before(cutoff) {
+			expired = append(expired, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, id := range expired {
+		// Removed first, so that the next purge tries again when this
+		// fails. Users restored meanwhile lose nothing they need, their
+		// tokens and sessions having been revoked when they were deleted.
+		if err := deleteUserRecords(dbClient, id); err != nil {
+			return purged, err
+		}
+
+		// Users restored meanwhile are spared
+		err := dbClient.Users.DeleteIfDeletedBefore(dbClient.Ctx, id, cutoff)
+		if err == ErrUserNotFound {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
+
+// deleteUserRecords removes the tokens and sessions of the user with
+// the given id. Their audit events are kept, being the history of what
+// was done, and so are the revocations of their tokens, which expire
+// along with the tokens.
+func deleteUserRecords(dbClient *UsersClient, id string) error {
+	collections := []*mongo.Collection{
+		refreshTokensCollection(dbClient),
+		sessionsCollection(dbClient),
+		emailVerificationTokensCollection(dbClient),
+		passwordResetTokensCollection(dbClient),
+		invitationTokensCollection(dbClient),
+	}
+
+	for _, collection := range collections {
+		if _, err := collection.DeleteMany(dbClient.Ctx, bson.D{{Key: "userId", Value: id}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartDeletedUserPurge runs PurgeDeletedUsers in the background
+// every purge interval
+func StartDeletedUserPurge(dbClient *UsersClient) {
+	conf := config.GetConfig().Users
+	if conf.PurgeInterval <= 0 {
+		panic(errors.New("users.purgeInterval must be positive"))
+	}
+
+	go func() {
+		ticker := time.NewTicker(conf.PurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purged, err := PurgeDeletedUsers(dbClient, conf.DeletedRetention)
+			if err != nil {
+				fmt.Println("Error purging deleted users:", err)
+			} else if purged > 0 {
+				fmt.Println("Purged", purged, "deleted users")
+			}
+		}
+	}()
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"server/models"
+	"server/security"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	DEFAULT_PAGE_SIZE = 50
+	MAX_PAGE_SIZE     = 200
+
+	// Fields users can be sorted by, all of them indexed
+	SORTABLE_USER_FIELDS = []string{"name", "email", "title", "createdAt", "updatedAt"}
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// userCursor points right after the last user of a page. It is bound to
+// the sort it was created with, since it means nothing for another one.
+type userCursor struct {
+	Sort  string      `json:"s"`
+	Order string      `json:"o"`
+	Value interface{} `json:"v"`
+	ID    string      `json:"id"`
+}
+
+func encodeUserCursor(sortField string, order string, user models.User) (string, error) {
+	var value interface{}
+	switch sortField {
+	case "name":
+		value = user.Name
+	case "email":
+		value = user.Email
+	case "title":
+		value = user.Title
+	case "createdAt":
+		value = user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "updatedAt":
+		value = user.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	bytes, err := json.Marshal(userCursor{Sort: sortField, Order: order, Value: value, ID: user.ID})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func decodeUserCursor(encoded string, sortField string, order string) (userCursor, error) {
+	cursor := userCursor{}
+
+	bytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+
+	if err := json.Unmarshal(bytes, &cursor); err != nil {
+		return cursor, errInvalidCursor
+	}
+
+	if cursor.Sort != sortField || cursor.Order != order {
+		return cursor, errInvalidCursor
+	}
+
+	value, ok := cursor.Value.(string)
+	if !ok {
+		return cursor, errInvalidCursor
+	}
+
+	// Dates travel as strings in the cursor
+	if sortField == "createdAt" || sortField == "updatedAt" {
+		date, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return cursor, errInvalidCursor
+		}
+		cursor.Value = date
+	}
+
+	return cursor, nil
+}
+
+// afterCursorQuery matches the users sorted after cursor, ties on
+// the sort field being broken by _id
+func afterCursorQuery(cursor userCursor) (bson.D, error) {
+	id, err := primitive.ObjectIDFromHex(cursor.ID)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	operator := "$gt"
+	if cursor.Order == "desc" {
+		operator = "$lt"
+	}
+
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: cursor.Sort, Value: bson.D{{Key: operator, Value: cursor.Value}}}},
+		bson.D{
+			{Key: cursor.Sort, Value: cursor.Value},
+			{Key: "_id", Value: bson.D{{Key: operator, Value: id}}},
+		},
+	}}}, nil
+}
+
+// userFilterQuery turns the filters of args into a query.
+// Arguments are expected to be validated already.
+func userFilterQuery(args models.GetAllArgs) bson.D {
+	query := bson.D{}
+
+	textFilters := []struct {
+		field string
+		value string
+	}{
+		{"name", args.Name},
+		{"email", args.Email},
+		{"title", args.Title},
+	}
+	for _, filter := range textFilters {
+		if filter.value != "" {
+			pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.value), Options: "i"}
+			query = append(query, bson.E{Key: filter.field, Value: pattern})
+		}
+	}
+
+	roleConditions := bson.D{}
+	if args.Role != "" {
+		roleConditions = append(roleConditions, bson.E{Key: "$eq", Value: args.Role})
+	}
+	switch args.IsAdmin {
+	case "true":
+		roleConditions = append(roleConditions, bson.E{Key: "$all", Value: bson.A{security.ROLE_ADMIN}})
+	case "false":
+		roleConditions = append(roleConditions, bson.E{Key: "$ne", Value: security.ROLE_ADMIN})
+	}
+	if len(roleConditions) > 0 {
+		query = append(query, bson.E{Key: "roles", Value: roleConditions})
+	}
+
+	createdAt := bson.D{}
+	if date, ok := parseFilterDate(args.CreatedAfter); ok {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: date})
+	}
+	if date, ok := parseFilterDate(args.CreatedBefore); ok {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: date})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "createdAt", Value: createdAt})
+	}
+
+	return query
+}
+
+// parseFilterDate accepts RFC 3339 timestamps and plain dates
+func parseFilterDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, true
+	}
+
+	date, err := time.Parse(DATE_FORMAT, value)
+	return date, err == nil
+}
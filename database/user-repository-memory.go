@@ -0,0 +1,294 @@
+package database
+
+import (
+	"context"
+	"server/models"
+	"server/security"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserRepository keeps users in memory, for tests and local
+// experiments. It is safe for concurrent use, and behaves like the
+// Mongo repository: emails of undeleted users are unique, and users are handed out as
+// copies that changing does not affect the stored ones.
+type MemoryUserRepository struct {
+	mutex sync.RWMutex
+	users map[string]models.User
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[string]models.User{}}
+}
+
+func (repository *MemoryUserRepository) Create(ctx context.Context, user models.User) (models.User, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	user = normalizeUserEmails(user)
+	if repository.emailTaken(user) {
+		return models.User{}, ErrDuplicateEmail
+	}
+
+	// Object ids sort by creation, like in Mongo
+	user.ID = primitive.NewObjectID().Hex()
+	user.Revision = 0
+	repository.users[user.ID] = copyUser(user)
+	return copyUser(user), nil
+}
+
+func (repository *MemoryUserRepository) Get(ctx context.Context, id string) (models.User, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	user, ok := repository.users[id]
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+func (repository *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	email = normalizeEmail(email)
+	for _, user := range repository.users {
+		if user.Email == email && user.DeletedAt == nil {
+			return copyUser(user), nil
+		}
+	}
+
+	return models.User{}, ErrUserNotFound
+}
+
+func (repository *MemoryUserRepository) Update(ctx context.Context, user models.User) (models.User, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	stored, ok := repository.users[user.ID]
+	if !ok {
+		return models.User{}, ErrUserNotFound
+	}
+
+	if stored.Revision != user.Revision {
+		return models.User{}, ErrUserModified
+	}
+
+	user = normalizeUserEmails(user)
+	if repository.emailTaken(user) {
+		return models.User{}, ErrDuplicateEmail
+	}
+
+	user.Revision++
+	repository.users[user.ID] = copyUser(user)
+	return copyUser(user), nil
+}
+
+func (repository *MemoryUserRepository) Delete(ctx context.Context, id string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	if _, ok := repository.users[id]; !ok {
+		return ErrUserNotFound
+	}
+
+	delete(repository.users, id)
+	return nil
+}
+
+func (repository *MemoryUserRepository) DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	user, ok := repository.users[id]
+	if !ok || user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+		return ErrUserNotFound
+	}
+
+	delete(repository.users, id)
+	return nil
+}
+
+func (repository *MemoryUserRepository) List(ctx context.Context, query UserListQuery) ([]models.User, int64, error) {
+	var after *userCursor
+	if query.Cursor != "" {
+		cursor, err := decodeUserCursor(query.Cursor, query.Sort, query.Order)
+		if err != nil {
+			return make([]models.User, 0), 0, err
+		}
+		after = &cursor
+	}
+
+	repository.mutex.RLock()
+	matches := make([]models.User, 0)
+	for _, user := range repository.users {
+		if (user.DeletedAt != nil) == query.Deleted && (query.Status == "" || user.Status == query.Status) &&
+			matchesUserFilters(user, query.GetAllArgs) {
+			matches = append(matches, copyUser(user))
+		}
+	}
+	repository.mutex.RUnlock()
+
+	descending := query.Order == "desc"
+	sort.Slice(matches, func(i, j int) bool {
+		return compareUsers(matches[i], matches[j], query.Sort, descending) < 0
+	})
+
+	users := make([]models.User, 0)
+	for _, user := range matches {
+		if after != nil && compareToCursor(user, *after, descending) <= 0 {
+			continue
+		}
+
+		if len(users) == query.Limit {
+			break
+		}
+
+		user.Password = ""
+		users = append(users, user)
+	}
+
+	return users, int64(len(matches)), nil
+}
+
+// emailTaken tells whether user is undeleted, and another undeleted
+// user has their email. It expects the mutex to be held.
+func (repository *MemoryUserRepository) emailTaken(user models.User) bool {
+	if user.DeletedAt != nil {
+		return false
+	}
+
+	for id, other := range repository.users {
+		if id != user.ID && other.Email == user.Email && other.DeletedAt == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyUser copies the slices of user, so that the
+// stored user and the returned one share nothing
+func copyUser(user models.User) models.User {
+	user.Roles = copyStrings(user.Roles)
+	user.RecoveryCodes = copyStrings(user.RecoveryCodes)
+	user.PasswordHistory = copyStrings(user.PasswordHistory)
+	return user
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append(make([]string, 0, len(values)), values...)
+}
+
+// matchesUserFilters applies the filters of args the way userFilterQuery does
+func matchesUserFilters(user models.User, args models.GetAllArgs) bool {
+	textFilters := []struct {
+		field string
+		value string
+	}{
+		{user.Name, args.Name},
+		{user.Email, args.Email},
+		{user.Title, args.Title},
+	}
+	for _, filter := range textFilters {
+		if filter.value != "" && !strings.Contains(strings.ToLower(filter.field), strings.ToLower(filter.value)) {
+			return false
+		}
+	}
+
+	if args.Role != "" && !containsString(user.Roles, args.Role) {
+		return false
+	}
+
+	isAdmin := containsString(user.Roles, security.ROLE_ADMIN)
+	if (args.IsAdmin == "true" && !isAdmin) || (args.IsAdmin == "false" && isAdmin) {
+		return false
+	}
+
+	if date, ok := parseFilterDate(args.CreatedAfter); ok && user.CreatedAt.Before(date) {
+		return false
+	}
+	if date, ok := parseFilterDate(args.CreatedBefore); ok && !user.CreatedAt.Before(date) {
+		return false
+	}
+
+	return true
+}
+
+// compareUsers orders users on the sort field, then on their id
+func compareUsers(a models.User, b models.User, sortField string, descending bool) int {
+	result := compareSortValues(userSortValue(a, sortField), userSortValue(b, sortField))
+	if result == 0 {
+		result = strings.Compare(a.ID, b.ID)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// compareToCursor orders user relatively to the last user of the previous page
+func compareToCursor(user models.User, cursor userCursor, descending bool) int {
+	result := compareSortValues(userSortValue(user, cursor.Sort), cursor.Value)
+	if result == 0 {
+		result = strings.Compare(user.ID, cursor.ID)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+func userSortValue(user models.User, sortField string) interface{} {
+	switch sortField {
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "title":
+		return user.Title
+	case "updatedAt":
+		return user.UpdatedAt
+	}
+
+	return user.CreatedAt
+}
+
+func compareSortValues(a interface{}, b interface{}) int {
+	if aTime, ok := a.(time.Time); ok {
+		bTime, _ := b.(time.Time)
+		switch {
+		case aTime.Before(bTime):
+			return -1
+		case aTime.After(bTime):
+			return 1
+		}
+		return 0
+	}
+
+	aString, _ := a.(string)
+	bString, _ := b.(string)
+	return strings.Compare(aString, bString)
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoUserRepository stores users in a MongoDB collection,
+// with a unique index on the email of undeleted users
+type MongoUserRepository struct {
+	Col *mongo.Collection
+}
+
+func NewMongoUserRepository(col *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{Col: col}
+}
+
+func (repository *MongoUserRepository) Create(ctx context.Context, user models.User) (models.User, error) {
+	user = normalizeUserEmails(user)
+	user.ID = ""
+	user.Revision = 0
+
+	result, err := repository.Col.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.User{}, ErrDuplicateEmail
+		}
+
+		return models.User{}, err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return user, nil
+}
+
+func (repository *MongoUserRepository) Get(ctx context.Context, id string) (models.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.User{}, ErrUserNotFound
+	}
+
+	return repository.findOne(ctx, bson.D{{Key: "_id", Value: objectID}})
+}
+
+func (repository *MongoUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	return repository.findOne(ctx, bson.D{{Key: "email", Value: normalizeEmail(email)}, notDeleted})
+}
+
+func (repository *MongoUserRepository) findOne(ctx context.Context, query bson.D) (models.User, error) {
+	user := models.User{}
+
+	err := repository.Col.FindOne(ctx, query).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrUserNotFound
+	}
+
+	return user, err
+}
+
+func (repository *MongoUserRepository) Update(ctx context.Context, user models.User) (models.User, error) {
+	objectID, err := primitive.ObjectIDFromHex(user.ID)
+	if err != nil {
+		return models.User{}, ErrUserNotFound
+	}
+
+	user = normalizeUserEmails(user)
+
+	// The replacement keeps the _id of the replaced user
+	replacement := user
+	replacement.ID = ""
+	replacement.Revision = user.Revision + 1
+
+	query := bson.D{{Key: "_id", Value: objectID}, revisionQuery(user.Revision)}
+	result, err := repository.Col.ReplaceOne(ctx, query, replacement)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return models.User{}, ErrDuplicateEmail
+		}
+
+		return models.User{}, err
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := repository.Col.CountDocuments(ctx, bson.D{{Key: "_id", Value: objectID}})
+		if err != nil {
+			return models.User{}, err
+		}
+
+		if count == 0 {
+			return models.User{}, ErrUserNotFound
+		}
+		return models.User{}, ErrUserModified
+	}
+
+	user.Revision = replacement.Revision
+	return user, nil
+}
+
+// revisionQuery matches users at revision, users
+// never updated since revisions exist having none
+func revisionQuery(revision int64) bson.E {
+	if revision == 0 {
+		return bson.E{Key: "revision", Value: bson.D{{Key: "$exists", Value: false}}}
+	}
+
+	return bson.E{Key: "revision", Value: revision}
+}
+
+func (repository *MongoUserRepository) Delete(ctx context.Context, id string) error {
+	return repository.deleteOne(ctx, id)
+}
+
+func (repository *MongoUserRepository) DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error {
+	return repository.deleteOne(ctx, id, bson.E{Key: "deletedAt", Value: bson.D{{Key: "$lt", Value: cutoff}}})
+}
+
+// deleteOne deletes the user with the given id if they match conditions
+func (repository *MongoUserRepository) deleteOne(ctx context.Context, id string, conditions ...bson.E) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	query := append(bson.D{{Key: "_id", Value: objectID}}, conditions...)
+	result, err := repository.Col.DeleteOne(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (repository *MongoUserRepository) List(ctx context.Context, query UserListQuery) ([]models.User, int64, error) {
+	users := make([]models.User, 0)
+
+	deleted := notDeleted
+	if query.Deleted {
+		deleted = isDeleted
+	}
+	filter := append(userFilterQuery(query.GetAllArgs), deleted)
+	if query.Status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: query.Status})
+	}
+
+	total, err := repository.Col.CountDocuments(ctx, filter)
+	if err != nil {
+		return users, 0, err
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeUserCursor(query.Cursor, query.Sort, query.Order)
+		if err != nil {
+			return users, 0, err
+		}
+
+		afterCursor, err := afterCursorQuery(cursor)
+		if err != nil {
+			return users, 0, err
+		}
+
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, afterCursor}}}
+	}
+
+	direction := 1
+	if query.Order == "desc" {
+		direction = -1
+	}
+
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "password", Value: 0}}).
+		SetSort(bson.D{{Key: query.Sort, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := repository.Col.Find(ctx, filter, opts)
+	if err != nil {
+		return users, 0, err
+	}
+
+	if err = cursor.All(ctx, &users); err != nil {
+		return users, 0, err
+	}
+
+	return users, total, nil
+}
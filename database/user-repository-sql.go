@@ -0,0 +1,532 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"server/models"
+	"server/security"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SQLUserRepository stores users in a SQL database, Postgres or SQLite,
+// whose schema SQL_MIGRATIONS create. It behaves like the Mongo repository.
+type SQLUserRepository struct {
+	DB      *sql.DB
+	Dialect SQLDialect
+}
+
+func NewSQLUserRepository(db *sql.DB, dialect SQLDialect) *SQLUserRepository {
+	return &SQLUserRepository{DB: db, Dialect: dialect}
+}
+
+// sqlStatement gathers the parameters of a statement
+// while it is written, numbered the way the dialect wants
+type sqlStatement struct {
+	dialect SQLDialect
+	args    []interface{}
+}
+
+// param adds value to the parameters, and returns its placeholder
+func (statement *sqlStatement) param(value interface{}) string {
+	statement.args = append(statement.args, value)
+	return statement.dialect.Placeholder(len(statement.args))
+}
+
+// sqlUserColumns are the columns of users, in the order
+// sqlUserValues and scanSQLUser list their values
+var sqlUserColumns = []string{
+	"id", "revision", "email", "name", "title", "birthdate", "password", "status",
+	"created_at", "updated_at", "deleted_at",
+	"email_verified", "email_verified_at", "pending_email",
+	"must_change_password", "password_changed_at", "password_expiry_reminder_at", "password_history",
+	"failed_login_count", "last_failed_login_at", "locked_until", "invitation_expires_at",
+	"two_factor_enabled", "two_factor_secret", "two_factor_pending_secret", "two_factor_last_step", "recovery_codes",
+}
+
+var userColumns = strings.Join(sqlUserColumns, ", ")
+
+func (repository *SQLUserRepository) Create(ctx context.Context, user models.User) (models.User, error) {
+	user = normalizeUserEmails(user)
+
+	// Object ids sort by creation, like in Mongo
+	user.ID = primitive.NewObjectID().Hex()
+	user.Revision = 0
+
+	tx, err := repository.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	values, err := sqlUserValues(user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	statement := sqlStatement{dialect: repository.Dialect}
+	params := make([]string, len(values))
+	for i, value := range values {
+		params[i] = statement.param(value)
+	}
+	query := `INSERT INTO users (` + userColumns + `) VALUES (` + strings.Join(params, `, `) + `)`
+
+	if _, err := tx.ExecContext(ctx, query, statement.args...); err != nil {
+		return models.User{}, repository.writeError(err)
+	}
+
+	if err := repository.insertRoles(ctx, tx, user); err != nil {
+		return models.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, repository.writeError(err)
+	}
+
+	return user, nil
+}
+
+func (repository *SQLUserRepository) Get(ctx context.Context, id string) (models.User, error) {
+	return repository.getIn(ctx, repository.DB, id)
+}
+
+func (repository *SQLUserRepository) FindByEmail(ctx context.Context, email string) (models.User, error) {
+	statement := sqlStatement{dialect: repository.Dialect}
+	// Users stored before emails were normalized may have upper case ones
+	query := `SELECT ` + userColumns + ` FROM users WHERE lower(email) = ` + statement.param(normalizeEmail(email)) +
+		` AND deleted_at IS NULL`
+
+	user, err := scanSQLUser(repository.DB.QueryRowContext(ctx, query, statement.args...))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return repository.withRoles(ctx, repository.DB, user)
+}
+
+func (repository *SQLUserRepository) Update(ctx context.Context, user models.User) (models.User, error) {
+	user = normalizeUserEmails(user)
+
+	tx, err := repository.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	updated := user
+	updated.Revision = user.Revision + 1
+
+	values, err := sqlUserValues(updated)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Every column but the id
+	statement := sqlStatement{dialect: repository.Dialect}
+	assignments := make([]string, 0, len(values)-1)
+	for i, value := range values[1:] {
+		assignments = append(assignments, sqlUserColumns[i+1]+` = `+statement.param(value))
+	}
+	query := `UPDATE users SET ` + strings.Join(assignments, `, `) +
+		` WHERE id = ` + statement.param(user.ID) + ` AND revision = ` + statement.param(user.Revision)
+
+	result, err := tx.ExecContext(ctx, query, statement.args...)
+	if err != nil {
+		return models.User{}, repository.writeError(err)
+	}
+
+	matched, err := result.RowsAffected()
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if matched == 0 {
+		if _, err := repository.getIn(ctx, tx, user.ID); err != nil {
+			return models.User{}, err
+		}
+		return models.User{}, ErrUserModified
+	}
+
+	statement = sqlStatement{dialect: repository.Dialect}
+	query = `DELETE FROM user_roles WHERE user_id = ` + statement.param(user.ID)
+	if _, err := tx.ExecContext(ctx, query, statement.args...); err != nil {
+		return models.User{}, err
+	}
+
+	if err := repository.insertRoles(ctx, tx, user); err != nil {
+		return models.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, repository.writeError(err)
+	}
+
+	return updated, nil
+}
+
+func (repository *SQLUserRepository) Delete(ctx context.Context, id string) error {
+	statement := sqlStatement{dialect: repository.Dialect}
+	condition := `id = ` + statement.param(id)
+	return repository.delete(ctx, id, &statement, condition)
+}
+
+func (repository *SQLUserRepository) DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error {
+	statement := sqlStatement{dialect: repository.Dialect}
+	condition := `id = ` + statement.param(id) + ` AND deleted_at < ` + statement.param(unixMillis(cutoff))
+	return repository.delete(ctx, id, &statement, condition)
+}
+
+// delete deletes the user with the given id, provided the row
+// matches condition, whose parameters statement holds
+func (repository *SQLUserRepository) delete(ctx context.Context, id string, statement *sqlStatement, condition string) error {
+	tx, err := repository.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM users WHERE ` + condition
+
+	result, err := tx.ExecContext(ctx, query, statement.args...)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrUserNotFound
+	}
+
+	roles := sqlStatement{dialect: repository.Dialect}
+	query = `DELETE FROM user_roles WHERE user_id = ` + roles.param(id)
+	if _, err := tx.ExecContext(ctx, query, roles.args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repository *SQLUserRepository) List(ctx context.Context, query UserListQuery) ([]models.User, int64, error) {
+	users := make([]models.User, 0)
+
+	column, ok := SQL_SORT_COLUMNS[query.Sort]
+	if !ok {
+		column = SQL_SORT_COLUMNS["createdAt"]
+	}
+
+	statement := sqlStatement{dialect: repository.Dialect}
+	conditions := repository.filterConditions(&statement, query)
+
+	var total int64
+	count := `SELECT COUNT(*) FROM users WHERE ` + strings.Join(conditions, ` AND `)
+	if err := repository.DB.QueryRowContext(ctx, count, statement.args...).Scan(&total); err != nil {
+		return users, 0, err
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeUserCursor(query.Cursor, query.Sort, query.Order)
+		if err != nil {
+			return users, 0, err
+		}
+
+		conditions = append(conditions, afterCursorCondition(&statement, column, cursor))
+	}
+
+	direction := `ASC`
+	if query.Order == "desc" {
+		direction = `DESC`
+	}
+
+	selection := `SELECT ` + userColumns + ` FROM users WHERE ` + strings.Join(conditions, ` AND `) +
+		` ORDER BY ` + column + ` ` + direction + `, id ` + direction
+	if query.Limit > 0 {
+		selection += ` LIMIT ` + statement.param(query.Limit)
+	}
+
+	rows, err := repository.DB.QueryContext(ctx, selection, statement.args...)
+	if err != nil {
+		return users, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanSQLUser(rows)
+		if err != nil {
+			return users, 0, err
+		}
+
+		user.Password = ""
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return users, 0, err
+	}
+	// Freeing the connection for the roles, SQLite having only one
+	rows.Close()
+
+	for i := range users {
+		if users[i], err = repository.withRoles(ctx, repository.DB, users[i]); err != nil {
+			return users, 0, err
+		}
+	}
+
+	return users, total, nil
+}
+
+// SQL_SORT_COLUMNS are the columns of the fields users can be sorted by
+var SQL_SORT_COLUMNS = map[string]string{
+	"name":      "name",
+	"email":     "email",
+	"title":     "title",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+// filterConditions turns the filters of query into the conditions
+// of a WHERE clause, the way userFilterQuery does for Mongo
+func (repository *SQLUserRepository) filterConditions(statement *sqlStatement, query UserListQuery) []string {
+	conditions := []string{`deleted_at IS NULL`}
+	if query.Deleted {
+		conditions = []string{`deleted_at IS NOT NULL`}
+	}
+
+	if query.Status != "" {
+		conditions = append(conditions, `status = `+statement.param(query.Status))
+	}
+
+	textFilters := []struct {
+		column string
+		value  string
+	}{
+		{"name", query.Name},
+		{"email", query.Email},
+		{"title", query.Title},
+	}
+	for _, filter := range textFilters {
+		if filter.value != "" {
+			pattern := "%" + escapeLikePattern(strings.ToLower(filter.value)) + "%"
+			conditions = append(conditions, `lower(`+filter.column+`) LIKE `+statement.param(pattern)+` ESCAPE '\'`)
+		}
+	}
+
+	hasRole := func(role string) string {
+		return `EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id AND user_roles.role = ` + statement.param(role) + `)`
+	}
+	if query.Role != "" {
+		conditions = append(conditions, hasRole(query.Role))
+	}
+	switch query.IsAdmin {
+	case "true":
+		conditions = append(conditions, hasRole(security.ROLE_ADMIN))
+	case "false":
+		conditions = append(conditions, `NOT `+hasRole(security.ROLE_ADMIN))
+	}
+
+	if date, ok := parseFilterDate(query.CreatedAfter); ok {
+		conditions = append(conditions, `created_at >= `+statement.param(unixMillis(date)))
+	}
+	if date, ok := parseFilterDate(query.CreatedBefore); ok {
+		conditions = append(conditions, `created_at < `+statement.param(unixMillis(date)))
+	}
+
+	return conditions
+}
+
+// afterCursorCondition matches the users sorted after cursor, ties on
+// the sort column being broken by id, like afterCursorQuery
+func afterCursorCondition(statement *sqlStatement, column string, cursor userCursor) string {
+	operator := `>`
+	if cursor.Order == "desc" {
+		operator = `<`
+	}
+
+	value := cursor.Value
+	if date, ok := value.(time.Time); ok {
+		value = unixMillis(date)
+	}
+
+	return `(` + column + ` ` + operator + ` ` + statement.param(value) +
+		` OR (` + column + ` = ` + statement.param(value) + ` AND id ` + operator + ` ` + statement.param(cursor.ID) + `))`
+}
+
+// escapeLikePattern escapes the wildcards of LIKE in value, with
+// the backslash the conditions declare as their escape character
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// insertRoles stores the roles of user, each once, in their order
+func (repository *SQLUserRepository) insertRoles(ctx context.Context, tx *sql.Tx, user models.User) error {
+	seen := map[string]bool{}
+	for _, role := range user.Roles {
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+
+		statement := sqlStatement{dialect: repository.Dialect}
+		query := `INSERT INTO user_roles (user_id, role, position) VALUES (` +
+			statement.param(user.ID) + `, ` + statement.param(role) + `, ` + statement.param(len(seen)) + `)`
+		if _, err := tx.ExecContext(ctx, query, statement.args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqlQuerier runs queries either on the database or in a transaction
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withRoles reads the roles of user
+func (repository *SQLUserRepository) withRoles(ctx context.Context, querier sqlQuerier, user models.User) (models.User, error) {
+	statement := sqlStatement{dialect: repository.Dialect}
+	query := `SELECT role FROM user_roles WHERE user_id = ` + statement.param(user.ID) + ` ORDER BY position`
+
+	rows, err := querier.QueryContext(ctx, query, statement.args...)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer rows.Close()
+
+	user.Roles = []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return models.User{}, err
+		}
+
+		user.Roles = append(user.Roles, role)
+	}
+
+	return user, rows.Err()
+}
+
+func (repository *SQLUserRepository) getIn(ctx context.Context, querier sqlQuerier, id string) (models.User, error) {
+	statement := sqlStatement{dialect: repository.Dialect}
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = ` + statement.param(id)
+
+	user, err := scanSQLUser(querier.QueryRowContext(ctx, query, statement.args...))
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return repository.withRoles(ctx, querier, user)
+}
+
+// writeError turns the violation of the unique email
+// index into the error the Mongo repository returns
+func (repository *SQLUserRepository) writeError(err error) error {
+	if repository.Dialect.IsUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
+
+// sqlRow is a row of users, read either alone or through a listing
+type sqlRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLUser(row sqlRow) (models.User, error) {
+	user := models.User{}
+
+	var createdAt, updatedAt int64
+	var birthdate, deletedAt, emailVerifiedAt, passwordChangedAt, passwordExpiryReminderAt sql.NullInt64
+	var lastFailedLoginAt, lockedUntil, invitationExpiresAt sql.NullInt64
+	var passwordHistory, recoveryCodes string
+
+	err := row.Scan(
+		&user.ID, &user.Revision, &user.Email, &user.Name, &user.Title, &birthdate, &user.Password, &user.Status,
+		&createdAt, &updatedAt, &deletedAt,
+		&user.EmailVerified, &emailVerifiedAt, &user.PendingEmail,
+		&user.MustChangePassword, &passwordChangedAt, &passwordExpiryReminderAt, &passwordHistory,
+		&user.FailedLoginCount, &lastFailedLoginAt, &lockedUntil, &invitationExpiresAt,
+		&user.TwoFactorEnabled, &user.TwoFactorSecret, &user.TwoFactorPendingSecret, &user.TwoFactorLastStep, &recoveryCodes,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return user, ErrUserNotFound
+		}
+
+		return user, err
+	}
+
+	if birthdate.Valid {
+		user.Birthdate = fromUnixMillis(birthdate.Int64)
+	}
+	user.CreatedAt = fromUnixMillis(createdAt)
+	user.UpdatedAt = fromUnixMillis(updatedAt)
+	user.DeletedAt = fromNullMillis(deletedAt)
+	user.EmailVerifiedAt = fromNullMillis(emailVerifiedAt)
+	user.PasswordChangedAt = fromNullMillis(passwordChangedAt)
+	user.PasswordExpiryReminderAt = fromNullMillis(passwordExpiryReminderAt)
+	user.LastFailedLoginAt = fromNullMillis(lastFailedLoginAt)
+	user.LockedUntil = fromNullMillis(lockedUntil)
+	user.InvitationExpiresAt = fromNullMillis(invitationExpiresAt)
+
+	if err := json.Unmarshal([]byte(passwordHistory), &user.PasswordHistory); err != nil {
+		return models.User{}, err
+	}
+	if err := json.Unmarshal([]byte(recoveryCodes), &user.RecoveryCodes); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// sqlUserValues lists the values of the columns of user
+func sqlUserValues(user models.User) ([]interface{}, error) {
+	passwordHistory, err := json.Marshal(user.PasswordHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := json.Marshal(user.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	var birthdate interface{}
+	if !user.Birthdate.IsZero() {
+		birthdate = unixMillis(user.Birthdate)
+	}
+
+	return []interface{}{
+		user.ID, user.Revision, user.Email, user.Name, user.Title, birthdate, user.Password, user.Status,
+		unixMillis(user.CreatedAt), unixMillis(user.UpdatedAt), nullMillis(user.DeletedAt),
+		user.EmailVerified, nullMillis(user.EmailVerifiedAt), user.PendingEmail,
+		user.MustChangePassword, nullMillis(user.PasswordChangedAt), nullMillis(user.PasswordExpiryReminderAt), string(passwordHistory),
+		user.FailedLoginCount, nullMillis(user.LastFailedLoginAt), nullMillis(user.LockedUntil), nullMillis(user.InvitationExpiresAt),
+		user.TwoFactorEnabled, user.TwoFactorSecret, user.TwoFactorPendingSecret, user.TwoFactorLastStep, string(recoveryCodes),
+	}, nil
+}
+
+// nullMillis turns an optional date into Unix milliseconds, or null
+func nullMillis(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+
+	return unixMillis(*t)
+}
+
+func fromNullMillis(millis sql.NullInt64) *time.Time {
+	if !millis.Valid {
+		return nil
+	}
+
+	t := fromUnixMillis(millis.Int64)
+	return &t
+}
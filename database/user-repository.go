@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"server/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Errors of the user repositories, whatever the storage behind them
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("email already in use")
+	// The user was updated since it was read
+	ErrUserModified = errors.New("user modified meanwhile")
+)
+
+// MAX_MODIFY_ATTEMPTS bounds how many times modifyUser starts over
+// when the user keeps being updated meanwhile, waiting a random delay
+// up to MODIFY_RETRY_DELAY times the attempt number in between
+var (
+	MAX_MODIFY_ATTEMPTS = 10
+	MODIFY_RETRY_DELAY  = 5 * time.Millisecond
+)
+
+// UserRepository stores users. Lookups by id see soft deleted and
+// invited users like the others, callers telling them apart. Emails are
+// stored and looked up in lower case, so they are unique whatever their
+// case, among the users that are not soft deleted: deleting a user frees
+// their email, and FindByEmail only finds undeleted users. Update replaces
+// the whole stored user with the given one, unless it was updated
+// since the given one was read, in which case it fails with
+// ErrUserModified. The returned user has its new revision.
+type UserRepository interface {
+	Create(ctx context.Context, user models.User) (models.User, error)
+	Get(ctx context.Context, id string) (models.User, error)
+	FindByEmail(ctx context.Context, email string) (models.User, error)
+	Update(ctx context.Context, user models.User) (models.User, error)
+	Delete(ctx context.Context, id string) error
+	// DeleteIfDeletedBefore deletes the user with the given id provided
+	// they were soft deleted before cutoff, checking it in the same
+	// operation, and fails with ErrUserNotFound otherwise
+	DeleteIfDeletedBefore(ctx context.Context, id string, cutoff time.Time) error
+	// List returns up to query.Limit users after query.Cursor, along
+	// with the count of every user matching the query
+	List(ctx context.Context, query UserListQuery) ([]models.User, int64, error)
+}
+
+// UserListQuery selects users with the filters of GetAllArgs, which are
+// expected to be validated already, and their Sort and Order to be set
+type UserListQuery struct {
+	models.GetAllArgs
+	// Soft deleted users are listed instead of the others
+	Deleted bool
+	// Only users of this status are listed when set
+	Status string
+}
+
+// normalizeEmail returns email the way repositories store and look it
+// up, so that addresses differing only by case are the same address
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// normalizeUserEmails normalizes the addresses of user before storing it
+func normalizeUserEmails(user models.User) models.User {
+	user.Email = normalizeEmail(user.Email)
+	user.PendingEmail = normalizeEmail(user.PendingEmail)
+	return user
+}
+
+// isActiveUser tells whether user exists for anything but restoring
+// them, that is unless soft deleted
+func isActiveUser(user models.User) bool {
+	return user.DeletedAt == nil
+}
+
+// userRepositoryError turns an error of a UserRepository
+// into the error the API answers with
+func userRepositoryError(err error) fiber.Error {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+	case errors.Is(err, ErrDuplicateEmail):
+		return fiber.Error{Code: fiber.StatusConflict, Message: ERROR_MESSAGE_EMAIL_ALREADY_IN_USE}
+	case errors.Is(err, ErrUserModified):
+		return fiber.Error{Code: fiber.StatusConflict, Message: ERROR_MESSAGE_USER_MODIFIED}
+	case errors.Is(err, errInvalidCursor):
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: err.Error()}
+	}
+
+	return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+}
+
+// getActiveUser gets the user with the given id, unless soft deleted
+func getActiveUser(ctx context.Context, users UserRepository, id string) (models.User, fiber.Error) {
+	user, err := users.Get(ctx, id)
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	if !isActiveUser(user) {
+		return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+	}
+
+	return user, fiber.Error{}
+}
+
+// modifyUser applies change to the user with the given id and stores
+// the result, starting over from the stored user when it was updated
+// meanwhile. An error of change aborts it and is returned as is, which
+// lets change refuse users it does not apply to.
+func modifyUser(ctx context.Context, users UserRepository, id string, change func(user *models.User) error) (models.User, error) {
+	for attempt := 0; attempt < MAX_MODIFY_ATTEMPTS; attempt++ {
+		user, err := users.Get(ctx, id)
+		if err != nil {
+			return models.User{}, err
+		}
+
+		if err := change(&user); err != nil {
+			return models.User{}, err
+		}
+
+		user, err = users.Update(ctx, user)
+		if err != ErrUserModified {
+			return user, err
+		}
+
+		time.Sleep(time.Duration(rand.Int63n(int64(attempt+1) * int64(MODIFY_RETRY_DELAY))))
+	}
+
+	return models.User{}, ErrUserModified
+}
+
+// modifyActiveUser is modifyUser for users who are not soft deleted
+func modifyActiveUser(ctx context.Context, users UserRepository, id string, change func(user *models.User) error) (models.User, error) {
+	return modifyUser(ctx, users, id, func(user *models.User) error {
+		if !isActiveUser(*user) {
+			return ErrUserNotFound
+		}
+
+		return change(user)
+	})
+}
+
+// eachUser calls fn on every user matching query, page after page,
+// in the order of creation. An error of fn stops it and is returned.
+func eachUser(ctx context.Context, users UserRepository, query UserListQuery, fn func(user models.User) error) error {
+	query.Sort = "createdAt"
+	query.Order = "asc"
+	query.Limit = MAX_PAGE_SIZE
+	query.Cursor = ""
+
+	for {
+		page, _, err := users.List(ctx, query)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range page {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < query.Limit {
+			return nil
+		}
+
+		query.Cursor, err = encodeUserCursor(query.Sort, query.Order, page[len(page)-1])
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"server/config"
+	"server/models"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// userRepositoryStores opens an empty repository of every store the
+// contract tests run against. Memory and SQLite always run, SQLite
+// provided the tests are built with cgo. Mongo and Postgres run when
+// TEST_MONGO_URI and TEST_POSTGRES_DSN point to a server to test with.
+func userRepositoryStores(t *testing.T) map[string]func(t *testing.T) UserRepository {
+	stores := map[string]func(t *testing.T) UserRepository{
+		"memory": func(t *testing.T) UserRepository {
+			return NewMemoryUserRepository()
+		},
+		"sqlite": func(t *testing.T) UserRepository {
+			return openTestSQLRepository(t, "sqlite", filepath.Join(t.TempDir(), "users.db"))
+		},
+	}
+
+	if uri := os.Getenv("TEST_MONGO_URI"); uri != "" {
+		stores["mongo"] = func(t *testing.T) UserRepository {
+			return openTestMongoRepository(t, uri)
+		}
+	}
+
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); dsn != "" {
+		stores["postgres"] = func(t *testing.T) UserRepository {
+			return openTestSQLRepository(t, "postgres", dsn)
+		}
+	}
+
+	return stores
+}
+
+func openTestSQLRepository(t *testing.T, driver string, dsn string) UserRepository {
+	ctx := context.Background()
+
+	db, dialect, err := OpenSQLDatabase(ctx, config.StorageConfiguration{Driver: driver, DSN: dsn})
+	if err != nil {
+		if strings.Contains(err.Error(), "cgo") {
+			t.Skip("SQLite needs the tests built with cgo")
+		}
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Postgres keeps its tables from a test to the next
+	if _, err := MigrateSQLDown(ctx, db, dialect, len(SQL_MIGRATIONS), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateSQLUp(ctx, db, dialect, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSQLUserRepository(db, dialect)
+}
+
+func openTestMongoRepository(t *testing.T, uri string) UserRepository {
+	ctx := context.Background()
+
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := mongoClient.Database("test_users_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		mongoClient.Disconnect(ctx)
+	})
+
+	collection := db.Collection("users")
+	client := &UsersClient{Ctx: ctx, DB: db, Col: collection, Users: NewMongoUserRepository(collection)}
+	if _, err := migrateMongoUp(client, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return client.Users
+}
+
+func newTestUser(email string) models.User {
+	now := time.Now()
+	return models.User{
+		Name:      "Jane Doe",
+		Email:     email,
+		Title:     "Engineer",
+		Roles:     []string{},
+		Status:    models.USER_STATUS_ACTIVE,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// testUserRepository runs test against every store
+func testUserRepository(t *testing.T, test func(t *testing.T, ctx context.Context, users UserRepository)) {
+	for name, open := range userRepositoryStores(t) {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			test(t, context.Background(), open(t))
+		})
+	}
+}
+
+func TestUserRepositoryCreateAndGet(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		created, err := users.Create(ctx, newTestUser("jane@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == "" {
+			t.Fatal("created user has no id")
+		}
+
+		got, err := users.Get(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Email != "jane@example.com" || got.Name != "Jane Doe" {
+			t.Errorf("got %+v, want the created user", got)
+		}
+
+		found, err := users.FindByEmail(ctx, "jane@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != created.ID {
+			t.Errorf("found user %s, want %s", found.ID, created.ID)
+		}
+
+		if _, err := users.Get(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("got %v for an unknown user, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestUserRepositoryAllFields(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		// Dates are stored to the millisecond
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		later := now.Add(time.Hour)
+
+		user := newTestUser("jane@example.com")
+		user.Birthdate = time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+		user.Password = "hash"
+		user.Roles = []string{"editor", "admin"}
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &now
+		user.PendingEmail = "jane.doe@example.com"
+		user.MustChangePassword = true
+		user.PasswordChangedAt = &now
+		user.PasswordExpiryReminderAt = &later
+		user.PasswordHistory = []string{"old", "older"}
+		user.FailedLoginCount = 2
+		user.LastFailedLoginAt = &now
+		user.LockedUntil = &later
+		user.InvitationExpiresAt = &later
+		user.TwoFactorEnabled = true
+		user.TwoFactorSecret = "secret"
+		user.TwoFactorPendingSecret = "pending"
+		user.TwoFactorLastStep = 42
+		user.RecoveryCodes = []string{"code"}
+
+		created, err := users.Create(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := users.Get(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, created) {
+			t.Errorf("got %+v, want %+v", got, created)
+		}
+	})
+}
+
+func TestUserRepositoryUniqueEmail(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		if _, err := users.Create(ctx, newTestUser("jane@example.com")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := users.Create(ctx, newTestUser("jane@example.com")); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("got %v creating a user with a taken email, want ErrDuplicateEmail", err)
+		}
+
+		other, err := users.Create(ctx, newTestUser("john@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other.Email = "jane@example.com"
+		if _, err := users.Update(ctx, other); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("got %v updating a user to a taken email, want ErrDuplicateEmail", err)
+		}
+	})
+}
+
+func TestUserRepositoryDeletedUserEmail(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		deleted := newTestUser("jane@example.com")
+		deletedAt := time.Now().Add(-time.Hour)
+		deleted.DeletedAt = &deletedAt
+
+		deleted, err := users.Create(ctx, deleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := users.FindByEmail(ctx, "jane@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("got %v finding a deleted user by email, want ErrUserNotFound", err)
+		}
+
+		created, err := users.Create(ctx, newTestUser("jane@example.com"))
+		if err != nil {
+			t.Fatalf("got %v reusing the email of a deleted user", err)
+		}
+
+		found, err := users.FindByEmail(ctx, "jane@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != created.ID {
+			t.Errorf("found user %s, want %s", found.ID, created.ID)
+		}
+
+		// Restoring the deleted user would give the email to both
+		deleted.DeletedAt = nil
+		if _, err := users.Update(ctx, deleted); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("got %v restoring a user whose email is taken, want ErrDuplicateEmail", err)
+		}
+	})
+}
+
+func TestUserRepositoryEmailCase(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		created, err := users.Create(ctx, newTestUser("Jane@Example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.Email != "jane@example.com" {
+			t.Errorf("got email %q, want it in lower case", created.Email)
+		}
+
+		found, err := users.FindByEmail(ctx, "JANE@example.COM")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.ID != created.ID {
+			t.Errorf("found user %s, want %s", found.ID, created.ID)
+		}
+
+		if _, err := users.Create(ctx, newTestUser("jane@EXAMPLE.com")); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("got %v creating a user with a taken email in another case, want ErrDuplicateEmail", err)
+		}
+	})
+}
+
+func TestUserRepositoryUpdateRevision(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		created, err := users.Create(ctx, newTestUser("jane@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stale := created
+		created.Title = "Manager"
+		updated, err := users.Update(ctx, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Revision == stale.Revision {
+			t.Error("update kept the revision")
+		}
+
+		stale.Title = "Director"
+		if _, err := users.Update(ctx, stale); !errors.Is(err, ErrUserModified) {
+			t.Errorf("got %v updating a stale user, want ErrUserModified", err)
+		}
+
+		got, err := users.Get(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Manager" {
+			t.Errorf("got title %q, want the one of the first update", got.Title)
+		}
+	})
+}
+
+func TestUserRepositoryDelete(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		created, err := users.Create(ctx, newTestUser("jane@example.com"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := users.Delete(ctx, created.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := users.Get(ctx, created.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("got %v getting a deleted user, want ErrUserNotFound", err)
+		}
+
+		if err := users.Delete(ctx, created.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("got %v deleting a deleted user, want ErrUserNotFound", err)
+		}
+
+		// The email is free again
+		if _, err := users.Create(ctx, newTestUser("jane@example.com")); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUserRepositoryDeleteIfDeletedBefore(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		cutoff := time.Now().Add(-time.Hour)
+		longAgo := cutoff.Add(-time.Hour)
+		recently := cutoff.Add(time.Minute)
+
+		tests := []struct {
+			email     string
+			deletedAt *time.Time
+			purged    bool
+		}{
+			{"active@example.com", nil, false},
+			{"recent@example.com", &recently, false},
+			{"expired@example.com", &longAgo, true},
+		}
+
+		for _, test := range tests {
+			user := newTestUser(test.email)
+			user.DeletedAt = test.deletedAt
+			created, err := users.Create(ctx, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = users.DeleteIfDeletedBefore(ctx, created.ID, cutoff)
+			if test.purged && err != nil {
+				t.Errorf("%s: got %v, want it deleted", test.email, err)
+			}
+			if !test.purged && !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("%s: got %v, want ErrUserNotFound", test.email, err)
+			}
+
+			_, err = users.Get(ctx, created.ID)
+			if deleted := errors.Is(err, ErrUserNotFound); deleted != test.purged {
+				t.Errorf("%s: deleted is %v, want %v", test.email, deleted, test.purged)
+			}
+		}
+	})
+}
+
+func TestUserRepositoryList(t *testing.T) {
+	testUserRepository(t, func(t *testing.T, ctx context.Context, users UserRepository) {
+		emails := []string{"a@example.com", "b@example.com", "c@example.com"}
+		for _, email := range emails {
+			if _, err := users.Create(ctx, newTestUser(email)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deleted := newTestUser("deleted@example.com")
+		deletedAt := time.Now()
+		deleted.DeletedAt = &deletedAt
+		if _, err := users.Create(ctx, deleted); err != nil {
+			t.Fatal(err)
+		}
+
+		query := UserListQuery{}
+		query.Sort = "createdAt"
+		query.Order = "asc"
+		query.Limit = 2
+
+		page, total, err := users.List(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 3 || len(page) != 2 || page[0].Email != "a@example.com" {
+			t.Fatalf("got %d users of %d starting with %v, want 2 of 3 starting with a@example.com", len(page), total, page)
+		}
+
+		query.Cursor, err = encodeUserCursor(query.Sort, query.Order, page[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, _, err = users.List(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].Email != "c@example.com" {
+			t.Errorf("got %v on the second page, want c@example.com", page)
+		}
+
+		query.Cursor = ""
+		query.Deleted = true
+		page, total, err = users.List(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 1 || len(page) != 1 || page[0].Email != "deleted@example.com" {
+			t.Errorf("got %v of %d soft deleted users, want deleted@example.com", page, total)
+		}
+	})
+}
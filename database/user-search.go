@@ -0,0 +1,368 @@
+package database
+
+import (
+	"regexp"
+	"server/models"
+	"server/validators"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var DEFAULT_SEARCH_LIMIT = 20
+
+// Bonuses added to the text score of users whose email, or one of
+// the words of their name, starts with the query, so that typing the
+// beginning of an address or a name is enough to find someone
+var (
+	SEARCH_SCORE_EXACT_EMAIL  = 100.0
+	SEARCH_SCORE_EMAIL_PREFIX = 50.0
+	SEARCH_SCORE_NAME_PREFIX  = 20.0
+	SEARCH_SCORE_FUZZY        = 1.0
+)
+
+// Words of queries shorter than this must match exactly, as about
+// every short word is a typo or two away from them
+var SEARCH_FUZZY_MIN_LENGTH = 4
+
+// Typos are looked for among at most SEARCH_FUZZY_MAX_CANDIDATES users,
+// whose email or a word of whose name starts like the longest word of
+// the query, its first SEARCH_FUZZY_PREFIX_LENGTH letters being typed right
+var (
+	SEARCH_FUZZY_MAX_CANDIDATES = 500
+	SEARCH_FUZZY_PREFIX_LENGTH  = 2
+)
+
+type scoredUser struct {
+	models.User `bson:",inline"`
+	Score       float64 `bson:"score"`
+}
+
+// SearchUsers looks users up by name, email or title, best matches first.
+// In Mongo, whole words are matched through the text index, and prefixes
+// of emails and of words of names through anchored regexes. Elsewhere,
+// substrings of the fields are matched through the repository filters.
+// When nothing matches, users a typo or two away from the query are
+// looked for instead.
+func SearchUsers(dbClient *UsersClient, args models.SearchUsersArgs) ([]models.User, fiber.Error) {
+	users := make([]models.User, 0)
+
+	// A blank query would match everyone
+	args.Q = strings.TrimSpace(args.Q)
+	validationError := validators.ValidateSearchUsersArgs(args, MAX_PAGE_SIZE)
+	if validationError != nil {
+		return users, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	if args.Limit == 0 {
+		args.Limit = DEFAULT_SEARCH_LIMIT
+	}
+
+	q := args.Q
+	scores := map[string]float64{}
+	found := map[string]models.User{}
+
+	var wordMatches []scoredUser
+	var prefixMatches []models.User
+	var err error
+	if repository, ok := dbClient.Users.(*MongoUserRepository); ok {
+		wordMatches, prefixMatches, err = searchMongoUsers(dbClient, repository, q, args.Limit)
+	} else {
+		wordMatches, prefixMatches, err = searchListedUsers(dbClient, q, args.Limit)
+	}
+	if err == nil && len(wordMatches) == 0 && len(prefixMatches) == 0 {
+		wordMatches, err = searchFuzzyUsers(dbClient, q)
+	}
+	if err != nil {
+		return users, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	for _, match := range wordMatches {
+		found[match.ID] = match.User
+		scores[match.ID] += match.Score
+	}
+
+	lowerQ := strings.ToLower(q)
+	for _, match := range prefixMatches {
+		found[match.ID] = match
+
+		email := strings.ToLower(match.Email)
+		if email == lowerQ {
+			scores[match.ID] += SEARCH_SCORE_EXACT_EMAIL
+		} else if strings.HasPrefix(email, lowerQ) {
+			scores[match.ID] += SEARCH_SCORE_EMAIL_PREFIX
+		}
+
+		for _, word := range strings.Fields(strings.ToLower(match.Name)) {
+			if strings.HasPrefix(word, lowerQ) {
+				scores[match.ID] += SEARCH_SCORE_NAME_PREFIX
+				break
+			}
+		}
+	}
+
+	for _, user := range found {
+		users = append(users, GetSafeUser(user))
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if scores[users[i].ID] != scores[users[j].ID] {
+			return scores[users[i].ID] > scores[users[j].ID]
+		}
+		return users[i].Name < users[j].Name
+	})
+
+	if len(users) > args.Limit {
+		users = users[:args.Limit]
+	}
+
+	return users, fiber.Error{}
+}
+
+// searchMongoUsers finds the users matching whole words of q through
+// the text index, which ranks them, and the users whose email or a word
+// of whose name starts with q through anchored regexes
+func searchMongoUsers(dbClient *UsersClient, repository *MongoUserRepository, q string, limit int) ([]scoredUser, []models.User, error) {
+	textQuery := bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: q}}}, notDeleted}
+	textOptions := options.Find().
+		SetProjection(bson.D{
+			{Key: "password", Value: 0},
+			{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}},
+		}).
+		SetSort(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetLimit(int64(limit))
+
+	var textMatches []scoredUser
+	if err := findInto(dbClient, repository, textQuery, textOptions, &textMatches); err != nil {
+		return nil, nil, err
+	}
+
+	prefixMatches, err := findMongoPrefixMatches(dbClient, repository, q, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return textMatches, prefixMatches, nil
+}
+
+// findMongoPrefixMatches finds up to limit users whose email or a word
+// of whose name starts with prefix. Emails being stored in lower case,
+// their regex is case sensitive, and anchored, for the index to serve it.
+func findMongoPrefixMatches(dbClient *UsersClient, repository *MongoUserRepository, prefix string, limit int) ([]models.User, error) {
+	escaped := regexp.QuoteMeta(strings.ToLower(prefix))
+	query := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "email", Value: primitive.Regex{Pattern: "^" + escaped}}},
+		bson.D{{Key: "name", Value: primitive.Regex{Pattern: `(^|\s)` + escaped, Options: "i"}}},
+	}}, notDeleted}
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "password", Value: 0}}).
+		SetLimit(int64(limit))
+
+	var matches []models.User
+	err := findInto(dbClient, repository, query, opts, &matches)
+	return matches, err
+}
+
+func findInto(dbClient *UsersClient, repository *MongoUserRepository, query bson.D, opts *options.FindOptions, results interface{}) error {
+	cursor, err := repository.Col.Find(dbClient.Ctx, query, opts)
+	if err != nil {
+		return err
+	}
+
+	return cursor.All(dbClient.Ctx, results)
+}
+
+// searchListedUsers finds the users whose name, email or title contains
+// q through the filters of the repository, for the repositories without
+// a text index. Matches are scored on the fields they match, weighed
+// like the text index weighs them.
+func searchListedUsers(dbClient *UsersClient, q string, limit int) ([]scoredUser, []models.User, error) {
+	fields := []struct {
+		args   models.GetAllArgs
+		weight float64
+	}{
+		{models.GetAllArgs{Name: q}, 10},
+		{models.GetAllArgs{Email: q}, 5},
+		{models.GetAllArgs{Title: q}, 2},
+	}
+
+	matches := map[string]*scoredUser{}
+	order := []string{}
+	for _, field := range fields {
+		query := UserListQuery{GetAllArgs: field.args}
+		query.Sort = "name"
+		query.Order = "asc"
+		query.Limit = limit
+
+		found, _, err := dbClient.Users.List(dbClient.Ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, user := range found {
+			if _, ok := matches[user.ID]; !ok {
+				matches[user.ID] = &scoredUser{User: user}
+				order = append(order, user.ID)
+			}
+			matches[user.ID].Score += field.weight
+		}
+	}
+
+	wordMatches := make([]scoredUser, 0, len(order))
+	prefixMatches := make([]models.User, 0, len(order))
+	for _, id := range order {
+		wordMatches = append(wordMatches, *matches[id])
+		prefixMatches = append(prefixMatches, matches[id].User)
+	}
+
+	return wordMatches, prefixMatches, nil
+}
+
+// searchFuzzyUsers finds the users of whose name, or the local part of
+// whose email, every word of q is within a few typos of a word. It is
+// only used when nothing matches q as it is, and only goes through the
+// candidates fuzzyCandidates returns.
+func searchFuzzyUsers(dbClient *UsersClient, q string) ([]scoredUser, error) {
+	matches := []scoredUser{}
+
+	queryWords := strings.Fields(strings.ToLower(q))
+	longest := ""
+	for _, queryWord := range queryWords {
+		if len([]rune(queryWord)) > len([]rune(longest)) {
+			longest = queryWord
+		}
+	}
+	if fuzzyMaxDistance(longest) == 0 {
+		return matches, nil
+	}
+
+	candidates, err := fuzzyCandidates(dbClient, string([]rune(longest)[:SEARCH_FUZZY_PREFIX_LENGTH]))
+	if err != nil {
+		return matches, err
+	}
+
+	for _, user := range candidates {
+		words := strings.Fields(strings.ToLower(user.Name))
+		if at := strings.Index(user.Email, "@"); at > 0 {
+			words = append(words, strings.ToLower(user.Email[:at]))
+		}
+
+		score := 0.0
+		matched := true
+		for _, queryWord := range queryWords {
+			maxDistance := fuzzyMaxDistance(queryWord)
+
+			best := maxDistance + 1
+			for _, word := range words {
+				if distance := editDistance(queryWord, word); distance < best {
+					best = distance
+				}
+			}
+
+			if best > maxDistance {
+				matched = false
+				break
+			}
+
+			// The closer, the better
+			score += SEARCH_SCORE_FUZZY * float64(maxDistance+1-best)
+		}
+
+		if matched {
+			matches = append(matches, scoredUser{User: user, Score: score})
+		}
+	}
+
+	return matches, nil
+}
+
+// fuzzyCandidates returns up to SEARCH_FUZZY_MAX_CANDIDATES users whose
+// email or name contains prefix, starting with it in Mongo
+func fuzzyCandidates(dbClient *UsersClient, prefix string) ([]models.User, error) {
+	if repository, ok := dbClient.Users.(*MongoUserRepository); ok {
+		return findMongoPrefixMatches(dbClient, repository, prefix, SEARCH_FUZZY_MAX_CANDIDATES)
+	}
+
+	candidates := []models.User{}
+	seen := map[string]bool{}
+	for _, args := range []models.GetAllArgs{{Name: prefix}, {Email: prefix}} {
+		query := UserListQuery{GetAllArgs: args}
+		query.Sort = "name"
+		query.Order = "asc"
+		query.Limit = SEARCH_FUZZY_MAX_CANDIDATES - len(candidates)
+		if query.Limit == 0 {
+			break
+		}
+
+		found, _, err := dbClient.Users.List(dbClient.Ctx, query)
+		if err != nil {
+			return candidates, err
+		}
+
+		for _, user := range found {
+			if !seen[user.ID] {
+				seen[user.ID] = true
+				candidates = append(candidates, user)
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// fuzzyMaxDistance is how many typos a word of a query is allowed to be off by
+func fuzzyMaxDistance(q string) int {
+	length := len([]rune(q))
+	switch {
+	case length < SEARCH_FUZZY_MIN_LENGTH:
+		return 0
+	case length < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the number of insertions, deletions, substitutions
+// and transpositions of adjacent letters turning a into b
+func editDistance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+
+	// Rows of the distances between the prefixes of s and t
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	beforePrevious := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				current[j] = minInt(current[j], beforePrevious[j-2]+1)
+			}
+		}
+
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+
+	return previous[len(t)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"server/models"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newTestSearchClient(t *testing.T, names map[string]string) *UsersClient {
+	ctx := context.Background()
+	users := NewMemoryUserRepository()
+
+	for email, name := range names {
+		user := newTestUser(email)
+		user.Name = name
+		if _, err := users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &UsersClient{Ctx: ctx, Users: users}
+}
+
+func TestSearchUsersBlankQuery(t *testing.T) {
+	dbClient := newTestSearchClient(t, map[string]string{"jane@example.com": "Jane Doe"})
+
+	for _, q := range []string{"", "   ", "\t\n"} {
+		users, err := SearchUsers(dbClient, models.SearchUsersArgs{Q: q})
+		if err.Code != fiber.StatusBadRequest {
+			t.Errorf("searching %q: got %v, want a bad request", q, err)
+		}
+		if len(users) != 0 {
+			t.Errorf("searching %q found %d users", q, len(users))
+		}
+	}
+}
+
+func TestSearchUsersTypos(t *testing.T) {
+	dbClient := newTestSearchClient(t, map[string]string{
+		"jane@example.com":   "Jane Doe",
+		"john@example.com":   "John Smith",
+		"robert@example.com": "Robert Johnson",
+	})
+
+	tests := []struct {
+		q     string
+		email string
+	}{
+		{"Smtih", "john@example.com"},
+		{"Jhon Smith", "john@example.com"},
+		{"robret", "robert@example.com"},
+	}
+
+	for _, test := range tests {
+		users, err := SearchUsers(dbClient, models.SearchUsersArgs{Q: test.q})
+		if (fiber.Error{}) != err {
+			t.Fatalf("searching %q: %v", test.q, err)
+		}
+		if len(users) == 0 || users[0].Email != test.email {
+			t.Errorf("searching %q found %v, want %s first", test.q, users, test.email)
+		}
+	}
+
+	// Short queries are too close to too many words
+	users, _ := SearchUsers(dbClient, models.SearchUsersArgs{Q: "Jon"})
+	if len(users) != 0 {
+		t.Errorf("searching %q found %v", "Jon", users)
+	}
+}
@@ -2,6 +2,10 @@ package database
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"server/config"
 	"server/models"
 	"server/security"
 	"server/util"
@@ -9,103 +13,322 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UsersClient struct {
 	Ctx context.Context
+	DB  *mongo.Database
+	// Col is the Mongo users collection, which only
+	// holds the users when they are stored in Mongo
 	Col *mongo.Collection
+	// Users stores the users, in whichever database the
+	// configuration selects. The other collections stay in DB.
+	Users UserRepository
 }
 
-var DEFAULT_PASSWORD = "defaultPassword1@"
-
-func Login(dbClient *UsersClient, args models.LoginArgs) (models.LoginResult, fiber.Error) {
+// Login checks the credentials of a user logging in, the actor of the
+// request telling from which IP and device. Failed attempts are throttled
+// per account and per IP, which are locked out after too many of them.
+// Throttled attempts are refused before checking the password, so that
+// they cost next to nothing. The others are counted as failed beforehand,
+// and taken back when they succeed, so that concurrent ones cannot
+// go past the limits.
+func Login(dbClient *UsersClient, actor models.Actor, args models.LoginArgs) (models.LoginResult, fiber.Error) {
 	result := models.LoginResult{}
+	conf := config.GetConfig()
+	ip := actor.IP
 
 	validationError := validators.ValidateLoginArgs(args)
 	if validationError != nil {
 		return result, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
 	}
 
-	// Query user with provided email
-	user := models.User{}
-	query := bson.D{{Key: "email", Value: args.Email}}
+	ipBefore, ipState, result, attemptError := countIPLoginAttempt(dbClient, ip)
+	if (fiber.Error{}) != attemptError {
+		return result, attemptError
+	}
+
+	// Deleted and invited users cannot log in
+	user, err := dbClient.Users.FindByEmail(dbClient.Ctx, args.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+	found := err == nil && isActiveUser(user) && user.Status != models.USER_STATUS_INVITED
+
+	// Unknown users are refused as late as wrong passwords,
+	// so that the time taken does not tell which emails have accounts
+	if !found {
+		util.CheckDummyPasswordHash(args.Password)
+		return failedLoginResult(ipState, models.LockoutState{})
+	}
+
+	user, result, attemptError = countAccountLoginAttempt(dbClient, user.ID)
+	if (fiber.Error{}) != attemptError {
+		// Throttled attempts do not count
+		if uncountError := uncountIPLoginAttempt(dbClient, ip, ipBefore, ipState); (fiber.Error{}) != uncountError {
+			return result, uncountError
+		}
+		return result, attemptError
+	}
+
+	if !util.CheckPasswordHash(args.Password, user.Password) {
+		return failedLoginResult(ipState, user.LockoutState)
+	}
 
-	err := dbClient.Col.FindOne(dbClient.Ctx, query).Decode(&user)
-	if (err != nil) || (user.Password != "" && !util.CheckPasswordHash(args.Password, user.Password)) {
-		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_LOGIN_FAILED}
+	if uncountError := uncountIPLoginAttempt(dbClient, ip, ipBefore, ipState); (fiber.Error{}) != uncountError {
+		return result, uncountError
+	}
+	if clearError := clearLoginFailures(dbClient, user); (fiber.Error{}) != clearError {
+		return result, clearError
+	}
+
+	// Hashes of former algorithms or parameters are upgraded
+	// while the password is at hand
+	if util.PasswordNeedsRehash(user.Password) {
+		rehashPassword(dbClient, user, args.Password)
+	}
+
+	if conf.Auth.RequireVerifiedEmail && !user.EmailVerified {
+		return result, fiber.Error{Code: fiber.StatusForbidden, Message: ERROR_MESSAGE_EMAIL_NOT_VERIFIED}
+	}
+
+	// The password alone is not enough with two-factor authentication
+	if user.TwoFactorEnabled {
+		return newMfaLoginResult(user)
 	}
 
-	token, tokenError := security.NewToken(&user)
+	return newLoginResult(dbClient, user, models.Session{UserAgent: actor.UserAgent, IP: actor.IP})
+}
+
+// rehashPassword replaces the password hash of user with one of the current
+// algorithm and parameters. Failures are only logged, the old hash working.
+func rehashPassword(dbClient *UsersClient, user models.User, password string) {
+	hashedPassword, err := util.HashPassword(password)
+	if err != nil {
+		fmt.Println("Error rehashing password:", err)
+		return
+	}
+
+	_, err = modifyUser(dbClient.Ctx, dbClient.Users, user.ID, func(current *models.User) error {
+		// Unless the password was changed meanwhile
+		if current.Password != user.Password {
+			return errPasswordChanged
+		}
+
+		current.Password = hashedPassword
+		return nil
+	})
+	if err != nil && err != errPasswordChanged {
+		fmt.Println("Error rehashing password:", err)
+	}
+}
+
+// errPasswordChanged aborts changes based on a password
+// that was changed since it was checked
+var errPasswordChanged = errors.New("password changed meanwhile")
+
+// failedLoginResult tells when the next login attempt will be allowed,
+// after a failed one counted in the lockout states of the IP and of the
+// account, which is empty when there is none
+func failedLoginResult(ipState models.LockoutState, accountState models.LockoutState) (models.LoginResult, fiber.Error) {
+	conf := config.GetConfig().Lockout
+	failed := fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_LOGIN_FAILED}
+
+	// Report whichever of the account and the IP has to wait longer
+	now := time.Now()
+	state := ipState
+	if nextAttemptAt(conf, accountState, now).After(nextAttemptAt(conf, ipState, now)) {
+		state = accountState
+	}
+
+	result := models.LoginResult{LockedUntil: state.LockedUntil}
+	if allowedAt := nextAttemptAt(conf, state, now); allowedAt.After(now) {
+		result.RetryAfter = int(math.Ceil(allowedAt.Sub(now).Seconds()))
+	}
+
+	return result, failed
+}
+
+// newLoginResult issues the tokens of session, opening it first on new
+// logins. Its ID is the family of the refresh tokens issued for it.
+func newLoginResult(dbClient *UsersClient, user models.User, session models.Session) (models.LoginResult, fiber.Error) {
+	result := models.LoginResult{}
+
+	// Users who must change their password, or whose password expired,
+	// only get a token to do so, and have to log in again afterwards
+	expired := passwordExpired(user)
+	if user.MustChangePassword || expired {
+		token, err := security.NewPasswordChangeToken(&user)
+		if err != nil {
+			return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		}
+
+		result.Token = token
+		result.PasswordChangeRequired = true
+		result.PasswordExpired = expired
+		result.User = GetSafeUser(user)
+		return result, fiber.Error{}
+	}
+
+	permissions, err := ResolvePermissions(dbClient, user.Roles)
+	if (fiber.Error{}) != err {
+		return result, err
+	}
+
+	// Every login opens a new session
+	if session.ID == "" {
+		session, err = openSession(dbClient, user.ID, models.Actor{IP: session.IP, UserAgent: session.UserAgent})
+		if (fiber.Error{}) != err {
+			return result, err
+		}
+	}
+
+	token, tokenError := security.NewToken(&user, permissions, session.ID)
 	if tokenError != nil {
 		return result, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
 	}
 
-	if !user.IsAdmin {
-		return result, fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_ACCESS_RESTRICTED}
+	refreshToken, err := issueRefreshToken(dbClient, user.ID, session.ID)
+	if (fiber.Error{}) != err {
+		return result, err
 	}
 
 	result.Token = token
+	result.RefreshToken = refreshToken
 	result.User = GetSafeUser(user)
 	return result, fiber.Error{}
 }
 
-func CreateByAdmin(dbClient *UsersClient, args models.CreateByAdminArgs) (models.User, fiber.Error) {
+// CreateByAdmin creates a user on behalf of an admin, with a random
+// temporary password returned only once, for the admin to pass on.
+// The user has to change it on their first login, and to verify
+// their email, which they are sent a link for.
+func CreateByAdmin(dbClient *UsersClient, actor models.Actor, args models.CreateByAdminArgs) (models.CreatedUser, fiber.Error) {
+	password, err := security.NewRandomPassword()
+	if err != nil {
+		return models.CreatedUser{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	}
+
+	createArgs := models.CreateWithPasswordArgs{Password: password, MustChangePassword: true, CreateByAdminArgs: args}
+	user, createError := createWithPassword(dbClient, actor, createArgs, false)
+	if (fiber.Error{}) != createError {
+		return models.CreatedUser{}, createError
+	}
+
+	// The user is created already, and can ask for a new link
+	if mailError := sendEmailVerification(dbClient, user, user.Email); (fiber.Error{}) != mailError {
+		fmt.Println("Error sending email verification to new user", user.ID)
+	}
+
+	return models.CreatedUser{User: user, TemporaryPassword: password}, fiber.Error{}
+}
+
+// CreateWithPassword creates a user along with their password, their
+// email counting as verified since whoever creates them vouches for it
+func CreateWithPassword(dbClient *UsersClient, actor models.Actor, args models.CreateWithPasswordArgs) (models.User, fiber.Error) {
+	return createWithPassword(dbClient, actor, args, true)
+}
+
+func createWithPassword(dbClient *UsersClient, actor models.Actor, args models.CreateWithPasswordArgs, emailVerified bool) (models.User, fiber.Error) {
 	user := models.User{}
 
-	validationError := validators.ValidateCreateByAdminArgs(args)
+	validationError := validators.ValidateCreateWithPasswordArgs(args)
 	if validationError != nil {
 		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
 	}
 
-	hashedPassword, err := util.HashPassword(DEFAULT_PASSWORD)
+	if rolesError := checkRolesExist(dbClient, args.Roles); (fiber.Error{}) != rolesError {
+		return user, rolesError
+	}
+
+	hashedPassword, err := util.HashPassword(args.Password)
 	if err != nil {
 		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 
-	// Parse args.CreateByAdminArgs.Birthdate
 	birthdate, err := time.Parse(DATE_FORMAT, args.Birthdate)
 	if err != nil {
 		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 
-	// Create a User object
-	// mostly from args
+	now := time.Now()
 	user = models.User{
 		Name:      args.Name,
 		Email:     args.Email,
 		Title:     args.Title,
 		Birthdate: birthdate,
 		Password:  hashedPassword,
-		IsAdmin:   args.IsAdmin,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Roles:     nonNilRoles(args.Roles),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Status:    models.USER_STATUS_ACTIVE,
+
+		MustChangePassword: args.MustChangePassword,
+		PasswordChangedAt:  &now,
+
+		EmailVerified: emailVerified,
+	}
+	if emailVerified {
+		user.EmailVerifiedAt = &now
 	}
 
-	result, err := dbClient.Col.InsertOne(dbClient.Ctx, user)
+	user, err = dbClient.Users.Create(dbClient.Ctx, user)
 	if err != nil {
-		if err.(mongo.WriteException).WriteErrors[0].Code == 11000 {
-			return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_EMAIL_ALREADY_IN_USE}
-		}
+		return models.User{}, userRepositoryError(err)
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_CREATE, user.ID, nil)
+
+	return GetSafeUser(user), fiber.Error{}
+}
+
+// IssueToken logs a user in without their credentials, opening a session
+// for actor like a login would. It is meant for operators, such as to
+// give scripts a token, and is recorded in the audit log.
+func IssueToken(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) (models.LoginResult, fiber.Error) {
+	user, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != err {
+		return models.LoginResult{}, err
+	}
+
+	// Invited users have no account until they accept
+	if user.Status == models.USER_STATUS_INVITED {
+		return models.LoginResult{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+	}
 
-		return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	result, err := newLoginResult(dbClient, user, models.Session{IP: actor.IP, UserAgent: actor.UserAgent})
+	if (fiber.Error{}) != err {
+		return result, err
 	}
 
-	// get the inserted user
-	user = models.User{}
-	query := bson.D{{Key: "_id", Value: result.InsertedID}}
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_TOKEN_ISSUE, user.ID, nil)
+
+	return result, fiber.Error{}
+}
+
+// GetByEmail returns the user with the given email, whatever its case
+func GetByEmail(ctx context.Context, users UserRepository, email string) (models.User, fiber.Error) {
+	user, err := users.FindByEmail(ctx, email)
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
 
-	if err := dbClient.Col.FindOne(dbClient.Ctx, query).Decode(&user); err != nil {
-		return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	if !isActiveUser(user) {
+		return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
 	}
 
 	return GetSafeUser(user), fiber.Error{}
 }
 
-func UpdateByAdmin(dbClient *UsersClient, id primitive.ObjectID, args models.UpdateByAdminArgs) (models.User, fiber.Error) {
+// errRolesNotManaged aborts updates changing the roles of a
+// user on behalf of someone who cannot manage roles
+var errRolesNotManaged = errors.New("roles cannot be changed")
+
+// UpdateByAdmin updates a user. Their roles only change when
+// canManageRoles is set, the update failing otherwise.
+func UpdateByAdmin(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, args models.UpdateByAdminArgs, canManageRoles bool) (models.User, fiber.Error) {
 	user := models.User{}
 
 	validationError := validators.ValidateUpdateByAdminArgs(args)
@@ -113,203 +336,391 @@ func UpdateByAdmin(dbClient *UsersClient, id primitive.ObjectID, args models.Upd
 		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
 	}
 
+	if rolesError := checkRolesExist(dbClient, args.Roles); (fiber.Error{}) != rolesError {
+		return user, rolesError
+	}
+
 	// Parse args.CreateByAdminArgs.Birthdate
 	birthdate, err := time.Parse(DATE_FORMAT, args.CreateByAdminArgs.Birthdate)
 	if err != nil {
 		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 
-	updateDoc := bson.D{
-		{Key: "name", Value: args.CreateByAdminArgs.Name},
-		{Key: "email", Value: args.CreateByAdminArgs.Email},
-		{Key: "title", Value: args.CreateByAdminArgs.Title},
-		{Key: "birthdate", Value: birthdate},
-		{Key: "isAdmin", Value: args.CreateByAdminArgs.IsAdmin},
-		{Key: "updatedAt", Value: time.Now()},
+	// The email only changes once the new address is verified,
+	// but it has to be free already
+	inUse, inUseError := emailInUse(dbClient, args.CreateByAdminArgs.Email, id)
+	if (fiber.Error{}) != inUseError {
+		return user, inUseError
 	}
-
-	query := bson.D{{Key: "_id", Value: id}}
-	update := bson.D{
-		{Key: "$set", Value: updateDoc},
+	if inUse {
+		return user, fiber.Error{Code: fiber.StatusConflict, Message: ERROR_MESSAGE_EMAIL_ALREADY_IN_USE}
 	}
 
-	err = dbClient.Col.FindOneAndUpdate(dbClient.Ctx, query, update).Err()
+	previous := models.User{}
+	newEmail := normalizeEmail(args.CreateByAdminArgs.Email)
+	user, err = modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		previous = *user
+
+		// Checked against the roles the update replaces, so
+		// that they cannot change in between unchecked
+		if !canManageRoles && !sameRoles(user.Roles, args.CreateByAdminArgs.Roles) {
+			return errRolesNotManaged
+		}
+
+		user.Name = args.CreateByAdminArgs.Name
+		user.Title = args.CreateByAdminArgs.Title
+		user.Birthdate = birthdate
+		user.Roles = nonNilRoles(args.CreateByAdminArgs.Roles)
+		user.UpdatedAt = time.Now()
+
+		// Changing the email back cancels the pending change
+		if newEmail == user.Email {
+			user.PendingEmail = ""
+		}
+		return nil
+	})
+	if err == errRolesNotManaged {
+		return models.User{}, fiber.Error{Code: fiber.StatusForbidden, Message: ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_ROLES_MANAGE}
+	}
 	if err != nil {
-		if err.(mongo.WriteException).WriteErrors[0].Code == 11000 {
-			return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_EMAIL_ALREADY_IN_USE}
-		} else if err == mongo.ErrNoDocuments {
-			return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+		return models.User{}, userRepositoryError(err)
+	}
+
+	if newEmail != previous.Email && newEmail != previous.PendingEmail {
+		if changeError := requestEmailChange(dbClient, previous, newEmail); (fiber.Error{}) != changeError {
+			return models.User{}, changeError
 		}
+	}
 
-		return models.User{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	// A user whose roles changed has to log in again
+	// to get a token with their new permissions
+	if !sameRoles(previous.Roles, args.CreateByAdminArgs.Roles) {
+		if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+			return models.User{}, revokeError
+		}
 	}
 
 	// get updated data
-	user = models.User{}
-	dbClient.Col.FindOne(dbClient.Ctx, query).Decode(&user)
+	user, err = dbClient.Users.Get(dbClient.Ctx, id.Hex())
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	changes := diffUsers(previous, user)
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_UPDATE, id.Hex(), changes)
 
 	return GetSafeUser(user), fiber.Error{}
 }
 
-func Delete(dbClient *UsersClient, id primitive.ObjectID) fiber.Error {
-	// find and delete todo
-	query := bson.D{{Key: "_id", Value: id}}
+// Delete soft deletes a user: they can be restored until
+// the purger removes them for good after the retention period
+func Delete(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) fiber.Error {
+	user, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != err {
+		return err
+	}
+
+	// mark the user as deleted
+	now := time.Now()
+	user.DeletedAt = &now
 
-	err := dbClient.Col.FindOneAndDelete(dbClient.Ctx, query).Err()
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
-		}
+	if _, err := dbClient.Users.Update(dbClient.Ctx, user); err != nil {
+		return userRepositoryError(err)
+	}
 
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+		return revokeError
 	}
 
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_DELETE, id.Hex(), nil)
+
 	return fiber.Error{}
 }
 
-func GetAll(dbClient *UsersClient) ([]models.User, fiber.Error) {
-	query := bson.D{{}}
-	projection := options.Find().SetProjection(bson.D{{Key: "password", Value: 0}})
-
-	var users []models.User = make([]models.User, 0)
-	cursor, err := dbClient.Col.Find(dbClient.Ctx, query, projection)
+// Restore brings back a soft deleted user
+func Restore(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) (models.User, fiber.Error) {
+	user, err := dbClient.Users.Get(dbClient.Ctx, id.Hex())
 	if err != nil {
-		return users, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		return models.User{}, userRepositoryError(err)
 	}
 
-	// iterate the cursor and decode each item into a User
-	if err = cursor.All(dbClient.Ctx, &users); err != nil {
-		return users, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	if isActiveUser(user) {
+		return models.User{}, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
 	}
 
-	return users, fiber.Error{}
+	user.DeletedAt = nil
+	user.UpdatedAt = time.Now()
+
+	if _, err := dbClient.Users.Update(dbClient.Ctx, user); err != nil {
+		return models.User{}, userRepositoryError(err)
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_RESTORE, id.Hex(), nil)
+
+	return GetSafeUser(user), fiber.Error{}
 }
 
-func GetById(dbClient *UsersClient, id primitive.ObjectID) (models.User, fiber.Error) {
-	user := models.User{}
-	query := bson.D{{Key: "_id", Value: id}}
+// GetAll returns one page of the users matching the filters of args,
+// along with the cursor of the next page
+func GetAll(ctx context.Context, users UserRepository, args models.GetAllArgs) (models.UserPage, fiber.Error) {
+	return getUserPage(ctx, users, UserListQuery{GetAllArgs: args})
+}
 
-	err := dbClient.Col.FindOne(dbClient.Ctx, query).Decode(&user)
+// GetDeleted lists soft deleted users like GetAll lists the others
+func GetDeleted(ctx context.Context, users UserRepository, args models.GetAllArgs) (models.UserPage, fiber.Error) {
+	return getUserPage(ctx, users, UserListQuery{GetAllArgs: args, Deleted: true})
+}
+
+// getUserPage lists the users matching query, whose
+// paging arguments are validated and defaulted here
+func getUserPage(ctx context.Context, users UserRepository, query UserListQuery) (models.UserPage, fiber.Error) {
+	args := query.GetAllArgs
+	page := models.UserPage{Users: make([]models.User, 0)}
+
+	validationError := validators.ValidateGetAllArgs(args, SORTABLE_USER_FIELDS, MAX_PAGE_SIZE)
+	if validationError != nil {
+		return page, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	if args.Limit == 0 {
+		args.Limit = DEFAULT_PAGE_SIZE
+	}
+	if args.Sort == "" {
+		args.Sort = "createdAt"
+	}
+	if args.Order == "" {
+		args.Order = "asc"
+	}
+
+	// Fetch one extra user to know whether there is a next page
+	query.GetAllArgs = args
+	query.Limit = args.Limit + 1
+
+	found, total, err := users.List(ctx, query)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return user, fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+		return page, userRepositoryError(err)
+	}
+	page.Total = total
+
+	if len(found) > args.Limit {
+		found = found[:args.Limit]
+
+		nextCursor, err := encodeUserCursor(args.Sort, args.Order, found[len(found)-1])
+		if err != nil {
+			return page, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
 		}
+		page.NextCursor = nextCursor
+	}
 
-		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	for _, user := range found {
+		page.Users = append(page.Users, GetSafeUser(user))
+	}
+
+	return page, fiber.Error{}
+}
+
+func GetById(ctx context.Context, users UserRepository, id primitive.ObjectID) (models.User, fiber.Error) {
+	user, err := getActiveUser(ctx, users, id.Hex())
+	if (fiber.Error{}) != err {
+		return user, err
 	}
 
 	return GetSafeUser(user), fiber.Error{}
 }
 
-func ResetUserPassword(dbClient *UsersClient, id primitive.ObjectID) fiber.Error {
-	hashedPassword, err := util.HashPassword(DEFAULT_PASSWORD)
+// ResetUserPassword emails the user a link to choose a new password,
+// like if they had forgotten theirs. Until they do, logging in with
+// their current password only lets them change it.
+func ResetUserPassword(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID) fiber.Error {
+	user, err := modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(user *models.User) error {
+		user.MustChangePassword = true
+		user.UpdatedAt = time.Now()
+		return nil
+	})
 	if err != nil {
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+		return userRepositoryError(err)
 	}
 
-	updateDoc := bson.D{
-		{Key: "password", Value: hashedPassword},
-		{Key: "updatedAt", Value: time.Now()},
+	// Sessions opened with the current password end here
+	if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+		return revokeError
 	}
 
-	query := bson.D{{Key: "_id", Value: id}}
-	update := bson.D{
-		{Key: "$set", Value: updateDoc},
+	if mailError := sendPasswordResetEmail(dbClient, user); (fiber.Error{}) != mailError {
+		return mailError
 	}
 
-	err = dbClient.Col.FindOneAndUpdate(dbClient.Ctx, query, update).Err()
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
-		}
-
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
-	}
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_PASSWORD_RESET, id.Hex(), nil)
 
 	return fiber.Error{}
 }
 
-func ChangePassword(dbClient *UsersClient, id primitive.ObjectID, args models.ChangePasswordArgs) fiber.Error {
+// ChangePassword sets the password of another user, who then has
+// to change it since it is known to whoever set it
+func ChangePassword(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, args models.ChangePasswordArgs) fiber.Error {
 	validationError := validators.ValidateChangePasswordArgs(args)
 	if validationError != nil {
 		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
 	}
 
-	hashedPassword, err := util.HashPassword(args.Password)
-	if err != nil {
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	if err := setPassword(dbClient, id, args.Password, true); (fiber.Error{}) != err {
+		return err
+	}
+
+	// Whoever knew the previous password is signed out
+	if revokeError := RevokeUserTokens(dbClient, id.Hex()); (fiber.Error{}) != revokeError {
+		return revokeError
 	}
 
-	updateDoc := bson.D{
-		{Key: "password", Value: hashedPassword},
-		{Key: "updatedAt", Value: time.Now()},
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_PASSWORD_CHANGE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
+
+// setPassword replaces the password of a user, unless it is one of
+// their recent passwords. The replaced hash joins the password history.
+func setPassword(dbClient *UsersClient, id primitive.ObjectID, password string, mustChange bool) fiber.Error {
+	historySize := config.GetConfig().Password.Policy.HistorySize
+
+	user, getError := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != getError {
+		return getError
 	}
 
-	query := bson.D{{Key: "_id", Value: id}}
-	update := bson.D{
-		{Key: "$set", Value: updateDoc},
+	if passwordReused(user, password, historySize) {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_PASSWORD_REUSED}
 	}
 
-	err = dbClient.Col.FindOneAndUpdate(dbClient.Ctx, query, update).Err()
+	hashedPassword, err := util.HashPassword(password)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return fiber.Error{Code: fiber.StatusNotFound, Message: ERROR_MESSAGE_USER_NOT_FOUND}
+		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+
+	now := time.Now()
+	_, err = modifyActiveUser(dbClient.Ctx, dbClient.Users, id.Hex(), func(current *models.User) error {
+		// The history was only checked against the password of then
+		if current.Password != user.Password {
+			return ErrUserModified
 		}
 
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+		if historySize > 0 && current.Password != "" {
+			current.PasswordHistory = append(current.PasswordHistory, current.Password)
+			if len(current.PasswordHistory) > historySize {
+				current.PasswordHistory = current.PasswordHistory[len(current.PasswordHistory)-historySize:]
+			}
+		}
+
+		current.Password = hashedPassword
+		current.MustChangePassword = mustChange
+		current.PasswordChangedAt = &now
+		current.PasswordExpiryReminderAt = nil
+		current.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return userRepositoryError(err)
 	}
 
 	return fiber.Error{}
 }
 
-func CreateDefaultAdmin(dbClient *UsersClient, args models.CreateDefaultAdminArgs) fiber.Error {
-	user := models.User{}
+// passwordReused tells whether password is the current password
+// of user or one of the historySize previous ones, no password
+// being checked when no history is kept
+func passwordReused(user models.User, password string, historySize int) bool {
+	if historySize <= 0 {
+		return false
+	}
+
+	if user.Password != "" && util.CheckPasswordHash(password, user.Password) {
+		return true
+	}
+
+	history := user.PasswordHistory
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+
+	for _, hash := range history {
+		if util.CheckPasswordHash(password, hash) {
+			return true
+		}
+	}
+
+	return false
+}
 
-	validationError := validators.ValidateCreateDefaultAdminArgs(args)
+// ChangeOwnPassword lets a user change their password, provided
+// they know the current one. Only their session sid goes on.
+func ChangeOwnPassword(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, sid string, args models.ChangePasswordArgs) fiber.Error {
+	validationError := validators.ValidateChangeOwnPasswordArgs(args)
 	if validationError != nil {
 		return fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
 	}
 
-	hashedPassword, err := util.HashPassword(args.Password)
-	if err != nil {
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	user, err := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != err {
+		return err
 	}
 
-	// Parse args.Birthdate
-	birthdate, err := time.Parse(DATE_FORMAT, args.Birthdate)
-	if err != nil {
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	if !util.CheckPasswordHash(args.CurrentPassword, user.Password) {
+		return fiber.Error{Code: fiber.StatusUnauthorized, Message: ERROR_MESSAGE_WRONG_PASSWORD}
 	}
 
-	// Create a User object
-	// mostly from args
-	user = models.User{
-		Name:      args.Name,
-		Email:     args.Email,
-		Title:     args.Title,
-		Birthdate: birthdate,
-		IsAdmin:   args.IsAdmin,
-		Password:  hashedPassword,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	// A password that had to be changed cannot be kept
+	if (user.MustChangePassword || passwordExpired(user)) && args.Password == args.CurrentPassword {
+		return fiber.Error{Code: fiber.StatusBadRequest, Message: ERROR_MESSAGE_SAME_PASSWORD}
+	}
+
+	if err := setPassword(dbClient, id, args.Password, false); (fiber.Error{}) != err {
+		return err
 	}
 
-	result, err := dbClient.Col.InsertOne(dbClient.Ctx, user)
+	// Every other session is signed out, in case the previous
+	// password leaked. Restricted tokens have no session to spare.
+	if revokeError := RevokeOtherUserTokens(dbClient, id.Hex(), sid); (fiber.Error{}) != revokeError {
+		return revokeError
+	}
+
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_PASSWORD_CHANGE, id.Hex(), nil)
+
+	return fiber.Error{}
+}
+
+// UpdateProfile lets a user edit the fields of their own profile
+// that do not affect their access: not their email, nor isAdmin
+func UpdateProfile(dbClient *UsersClient, actor models.Actor, id primitive.ObjectID, args models.UpdateProfileArgs) (models.User, fiber.Error) {
+	user := models.User{}
+
+	validationError := validators.ValidateUpdateProfileArgs(args)
+	if validationError != nil {
+		return user, fiber.Error{Code: fiber.StatusBadRequest, Message: validationError.Error()}
+	}
+
+	// Parse args.Birthdate
+	birthdate, err := time.Parse(DATE_FORMAT, args.Birthdate)
 	if err != nil {
-		if err.(mongo.WriteException).WriteErrors[0].Code == 11000 {
-			return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_EMAIL_ALREADY_IN_USE}
-		}
+		return user, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
 
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: ERROR_MESSAGE_SOMETHING_WENT_WRONG}
+	previous, getError := getActiveUser(dbClient.Ctx, dbClient.Users, id.Hex())
+	if (fiber.Error{}) != getError {
+		return user, getError
 	}
 
-	// get the inserted user
-	user = models.User{}
-	query := bson.D{{Key: "_id", Value: result.InsertedID}}
+	user = previous
+	user.Name = args.Name
+	user.Title = args.Title
+	user.Birthdate = birthdate
+	user.UpdatedAt = time.Now()
 
-	if err := dbClient.Col.FindOne(dbClient.Ctx, query).Decode(&user); err != nil {
-		return fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	user, err = dbClient.Users.Update(dbClient.Ctx, user)
+	if err != nil {
+		return models.User{}, userRepositoryError(err)
 	}
 
-	return fiber.Error{}
+	changes := diffUsers(previous, user)
+	recordAuditEvent(dbClient, actor, AUDIT_ACTION_USER_PROFILE_UPDATE, id.Hex(), changes)
+
+	return GetSafeUser(user), fiber.Error{}
 }
@@ -1,16 +1,54 @@
 package database
 
-import "server/models"
+import (
+	"server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 var (
 	ERROR_MESSAGE_SOMETHING_WENT_WRONG = "Something went wrong"
 	ERROR_MESSAGE_USER_NOT_FOUND       = "User not found"
 	ERROR_MESSAGE_EMAIL_ALREADY_IN_USE = "email already in use"
+	ERROR_MESSAGE_USER_MODIFIED        = "User was modified meanwhile, try again"
 	ERROR_MESSAGE_LOGIN_FAILED         = "Login failed"
-	ERROR_MESSAGE_ACCESS_RESTRICTED    = "Access limited to admins only"
+	ERROR_MESSAGE_PERMISSION_DENIED    = "Missing permission"
+	ERROR_MESSAGE_TARGET_OUTRANKS      = "The user holds permissions you lack"
+	ERROR_MESSAGE_UNKNOWN_ROLE         = "Unknown role"
+	ERROR_MESSAGE_ROLE_NOT_FOUND       = "Role not found"
+	ERROR_MESSAGE_ROLE_PROTECTED       = "The admin role cannot be deleted"
+	ERROR_MESSAGE_WRONG_PASSWORD       = "Current password is incorrect"
+	ERROR_MESSAGE_INVALID_REFRESH      = "Invalid or expired refresh token"
+	ERROR_MESSAGE_INVALID_RESET_TOKEN  = "Invalid or expired password reset token"
+	ERROR_MESSAGE_TOKEN_REVOKED        = "Token has been revoked"
+	ERROR_MESSAGE_SESSION_ENDED        = "Session has ended"
+	ERROR_MESSAGE_SESSION_NOT_FOUND    = "Session not found"
+	ERROR_MESSAGE_RESTRICTED_TOKEN     = "Token cannot be used for this request"
+	ERROR_MESSAGE_MUST_CHANGE_PASSWORD = "Password must be changed before anything else"
+	ERROR_MESSAGE_SAME_PASSWORD        = "New password must differ from the current one"
+	ERROR_MESSAGE_PASSWORD_REUSED      = "New password must differ from the recent ones"
+	ERROR_MESSAGE_INVALID_INVITATION   = "Invalid or expired invitation"
+	ERROR_MESSAGE_INVITATION_NOT_FOUND = "Invitation not found"
+	ERROR_MESSAGE_INVALID_EMAIL_TOKEN  = "Invalid or expired email verification token"
+	ERROR_MESSAGE_EMAIL_NOT_VERIFIED   = "Email address not verified"
+	ERROR_MESSAGE_NOTHING_TO_VERIFY    = "Email address already verified"
+	ERROR_MESSAGE_TOO_MANY_ATTEMPTS    = "Too many failed login attempts, try again later"
+	ERROR_MESSAGE_ACCOUNT_LOCKED       = "Account temporarily locked after too many failed login attempts"
+	ERROR_MESSAGE_RATE_LIMITED         = "Too many requests, try again later"
+	ERROR_MESSAGE_INVALID_MFA_CODE     = "Invalid two-factor code"
+	ERROR_MESSAGE_MFA_ALREADY_ENABLED  = "Two-factor authentication already enabled"
+	ERROR_MESSAGE_MFA_NOT_ENABLED      = "Two-factor authentication not enabled"
+	ERROR_MESSAGE_MFA_NOT_ENROLLED     = "Two-factor enrollment not started"
 	DATE_FORMAT                        = "2006-01-02"
 )
 
+// Soft deleted users have a deletedAt date, the others a null one,
+// or none when they were stored before the email index needed it
+var (
+	notDeleted = bson.E{Key: "deletedAt", Value: nil}
+	isDeleted  = bson.E{Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}
+)
+
 func GetSafeUser(user models.User) models.User {
 	return models.User{
 		ID:        user.ID,
@@ -18,8 +56,23 @@ func GetSafeUser(user models.User) models.User {
 		Email:     user.Email,
 		Title:     user.Title,
 		Birthdate: user.Birthdate,
-		IsAdmin:   user.IsAdmin,
+		Roles:     user.Roles,
 		CreatedAt: user.CreatedAt,
 		UpdatedAt: user.UpdatedAt,
+
+		DeletedAt: user.DeletedAt,
+
+		EmailVerified:   user.EmailVerified,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PendingEmail:    user.PendingEmail,
+
+		MustChangePassword: user.MustChangePassword,
+		PasswordChangedAt:  user.PasswordChangedAt,
+		LockoutState:       user.LockoutState,
+
+		Status:              user.Status,
+		InvitationExpiresAt: user.InvitationExpiresAt,
+
+		TwoFactorEnabled: user.TwoFactorEnabled,
 	}
 }
\ No newline at end of file
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AcceptInvitationHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	args, parsingError := util.RetrieveAcceptInvitationRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	user, err := database.AcceptInvitation(dbClient, util.RetrieveActor(c), args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
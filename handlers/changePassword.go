@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"server/database"
+	"server/security"
 	"server/util"
 
 	"github.com/gofiber/fiber/v2")
@@ -18,25 +19,42 @@ func ChangePasswordHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	isAdmin, err2 := util.IsRequestFromAdmin(c)
+	isSameUser, err := util.IsRequestFromSameUser(c)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	canChangePasswords, err2 := util.HasPermission(c, security.PERMISSION_USERS_PASSWORD_CHANGE)
 	if err2 != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
+			"message": err2.Error(),
 		})
 	}
 
-	if isAdmin {
-		err = database.ChangePassword(dbClient, id, args)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
-				"message": err.Error(),
-			})
+	claims := c.Locals("claims").(*security.MyCustomClaims)
+	if isSameUser {
+		// Users changing their own password,
+		// admins included, must confirm the current one
+		err = database.ChangeOwnPassword(dbClient, util.RetrieveActor(c), id, claims.SessionID, args)
+	} else if canChangePasswords {
+		// Nor can they take over a more powerful account
+		err = database.CheckPermissionsCover(dbClient, claims.Permissions, id)
+		if (fiber.Error{}) == err {
+			err = database.ChangePassword(dbClient, util.RetrieveActor(c), id, args)
 		}
-
-		return c.SendStatus(fiber.StatusOK)
 	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_USERS_PASSWORD_CHANGE,
 		})
 	}
+
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusOK)
 }
\ No newline at end of file
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"server/database"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestChangePasswordHandlerMalformedBody(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("dbClient", &database.UsersClient{})
+		return c.Next()
+	})
+	app.Put("/api/users/password/change/:id", ChangePasswordHandler)
+
+	req := httptest.NewRequest("PUT", "/api/users/password/change/"+primitive.NewObjectID().Hex(), strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("got status %d for a malformed body, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ConfirmTwoFactorHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, err := util.RetrieveIdFromToken(c)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	args, parsingError := util.RetrieveTwoFactorCodeRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	codes, err := database.ConfirmTwoFactor(dbClient, id, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(codes)
+}
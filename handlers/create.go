@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"server/database"
+	"server/security"
 	"server/util"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,30 +12,33 @@ func CreateHandler(c *fiber.Ctx) error {
 	// Access dbClient
 	dbClient := c.Locals("dbClient").(*database.UsersClient)
 
-	isAdmin, err := util.IsRequestFromAdmin(c)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
-	}
-
 	userDetails, parsingError := util.RetrieveCreateRequestData(c)
 	if parsingError != nil {
 		return util.HandleParsingError(c, parsingError)
 	}
 
-	if isAdmin {
-		user, err := database.CreateByAdmin(dbClient, userDetails)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
+	// Giving roles away is reserved to role managers
+	if len(userDetails.Roles) > 0 {
+		canManageRoles, err := util.HasPermission(c, security.PERMISSION_ROLES_MANAGE)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"message": err.Error(),
 			})
 		}
 
-		return c.Status(fiber.StatusCreated).JSON(user)
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
+		if !canManageRoles {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_ROLES_MANAGE,
+			})
+		}
+	}
+
+	user, err := database.CreateByAdmin(dbClient, util.RetrieveActor(c), userDetails)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
 		})
 	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
 }
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"server/database"
+	"server/security"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func CreateInvitationHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	userDetails, parsingError := util.RetrieveCreateRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	// Giving roles away is reserved to role managers
+	if len(userDetails.Roles) > 0 {
+		canManageRoles, err := util.HasPermission(c, security.PERMISSION_ROLES_MANAGE)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": err.Error(),
+			})
+		}
+
+		if !canManageRoles {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_ROLES_MANAGE,
+			})
+		}
+	}
+
+	user, err := database.CreateInvitation(dbClient, util.RetrieveActor(c), userDetails)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
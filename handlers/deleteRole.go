@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func DeleteRoleHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	err := database.DeleteRole(dbClient, util.RetrieveActor(c), util.RetrieveRoleName(c))
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func DisableTwoFactorHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, err := util.RetrieveIdFromToken(c)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	args, parsingError := util.RetrieveSecondFactorRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	err = database.DisableTwoFactor(dbClient, id, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
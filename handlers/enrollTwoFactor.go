@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func EnrollTwoFactorHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, err := util.RetrieveIdFromToken(c)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	enrollment, err := database.EnrollTwoFactor(dbClient, id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(enrollment)
+}
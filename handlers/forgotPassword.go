@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ForgotPasswordHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	args, parsingError := util.RetrieveForgotPasswordRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	err := database.ForgotPassword(dbClient, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
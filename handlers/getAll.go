@@ -8,28 +8,20 @@ import (
 )
 
 func GetAllHandler(c *fiber.Ctx) error {
-	// Access dbClient
-	dbClient := c.Locals("dbClient").(*database.UsersClient)
+	// Access the user repository
+	users := c.Locals("users").(database.UserRepository)
 
-	isAdmin, err := util.IsRequestFromAdmin(c)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
+	args, parsingError := util.RetrieveGetAllRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
 	}
 
-	if isAdmin {
-		users, err := database.GetAll(dbClient)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
-				"message": err.Error(),
-			})
-		}
-
-		return c.Status(fiber.StatusOK).JSON(users)
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
+	page, err := database.GetAll(c.Context(), users, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
 		})
 	}
+
+	return c.Status(fiber.StatusOK).JSON(page)
 }
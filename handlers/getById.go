@@ -8,33 +8,20 @@ import (
 )
 
 func GetByIdHandler(c *fiber.Ctx) error {
-	// Access dbClient
-	dbClient := c.Locals("dbClient").(*database.UsersClient)
+	// Access the user repository
+	users := c.Locals("users").(database.UserRepository)
 
 	id, retrievalError := util.RetrieveGetByIdRequestData(c)
 	if retrievalError != nil {
 		return util.HandleParsingError(c, retrievalError)
 	}
 
-	isAdmin, err := util.IsRequestFromAdmin(c)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	user, err := database.GetById(c.Context(), users, id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
 			"message": err.Error(),
 		})
 	}
 
-	if isAdmin {
-		user, err := database.GetById(dbClient, id)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
-				"message": err.Error(),
-			})
-		}
-
-		return c.Status(fiber.StatusOK).JSON(user)
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
-		})
-	}
+	return c.Status(fiber.StatusOK).JSON(user)
 }
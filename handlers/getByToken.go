@@ -9,8 +9,8 @@ import (
 
 
 func GetByTokenHandler(c *fiber.Ctx) error {
-	// Access dbClient
-	dbClient := c.Locals("dbClient").(*database.UsersClient)
+	// Access the user repository
+	users := c.Locals("users").(database.UserRepository)
 
 	id, err := util.RetrieveIdFromToken(c)
 	if (fiber.Error{}) != err {
@@ -19,25 +19,13 @@ func GetByTokenHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	isAdmin, err2 := util.IsRequestFromAdmin(c)
-	if err2 != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	// Any user can read their own profile
+	user, err := database.GetById(c.Context(), users, id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
 			"message": err.Error(),
 		})
 	}
 
-	if isAdmin {
-		user, err := database.GetById(dbClient, id)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
-				"message": err.Error(),
-			})
-		}
-
-		return c.Status(fiber.StatusOK).JSON(user)
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
-		})
-	}
-}
+	return c.Status(fiber.StatusOK).JSON(user)
+}
\ No newline at end of file
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func GetDeletedHandler(c *fiber.Ctx) error {
+	// Access the user repository
+	users := c.Locals("users").(database.UserRepository)
+
+	args, parsingError := util.RetrieveGetAllRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	page, err := database.GetDeleted(c.Context(), users, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(page)
+}
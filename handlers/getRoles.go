@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"server/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func GetRolesHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	roles, err := database.GetRoles(dbClient)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(roles)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"server/database"
+	"server/security"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func GetSessionsHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, retrievalError := util.RetrieveGetByIdRequestData(c)
+	if retrievalError != nil {
+		return util.HandleParsingError(c, retrievalError)
+	}
+
+	// Users see their own sessions, admins those of anyone
+	permitted, err := util.IsRequestFromSameUserOrPermitted(c, security.PERMISSION_USERS_READ)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	if !permitted {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_USERS_READ,
+		})
+	}
+
+	claims := c.Locals("claims").(*security.MyCustomClaims)
+
+	sessions, err := database.GetSessions(dbClient, id, claims.SessionID)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(sessions)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"server/database"
+	"server/models"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestApp serves the user reading handlers,
+// backed by users instead of a database
+func newTestApp(users database.UserRepository) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("users", users)
+		return c.Next()
+	})
+	app.Get("/api/users/all", GetAllHandler)
+	app.Get("/api/users/:id", GetByIdHandler)
+	return app
+}
+
+func createTestUser(t *testing.T, users database.UserRepository, email string, deleted bool) models.User {
+	now := time.Now()
+	user := models.User{
+		Name:      "Jane Doe",
+		Email:     email,
+		Password:  "hashed password",
+		Roles:     []string{},
+		Status:    models.USER_STATUS_ACTIVE,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if deleted {
+		user.DeletedAt = &now
+	}
+
+	created, err := users.Create(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return created
+}
+
+func TestGetByIdHandler(t *testing.T) {
+	users := database.NewMemoryUserRepository()
+	app := newTestApp(users)
+	jane := createTestUser(t, users, "jane@example.com", false)
+	deleted := createTestUser(t, users, "deleted@example.com", true)
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"existing user", jane.ID, fiber.StatusOK},
+		{"soft deleted user", deleted.ID, fiber.StatusNotFound},
+		{"unknown user", primitive.NewObjectID().Hex(), fiber.StatusNotFound},
+		{"invalid id", "nope", fiber.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := app.Test(httptest.NewRequest("GET", "/api/users/"+test.id, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", response.StatusCode, test.status)
+			}
+			if test.status != fiber.StatusOK {
+				return
+			}
+
+			user := models.User{}
+			if err := json.NewDecoder(response.Body).Decode(&user); err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != jane.ID || user.Email != jane.Email {
+				t.Errorf("got %+v, want %s", user, jane.Email)
+			}
+			if user.Password != "" {
+				t.Error("the password hash was returned")
+			}
+		})
+	}
+}
+
+func TestGetAllHandler(t *testing.T) {
+	users := database.NewMemoryUserRepository()
+	app := newTestApp(users)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		createTestUser(t, users, email, false)
+	}
+	createTestUser(t, users, "deleted@example.com", true)
+
+	response, err := app.Test(httptest.NewRequest("GET", "/api/users/all?limit=2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", response.StatusCode, fiber.StatusOK)
+	}
+
+	page := models.UserPage{}
+	if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Users) != 2 || page.NextCursor == "" {
+		t.Fatalf("got %d users of %d, want the first 2 of 3 and a cursor", len(page.Users), page.Total)
+	}
+
+	response, err = app.Test(httptest.NewRequest("GET", "/api/users/all?limit=2&cursor="+page.NextCursor, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := models.UserPage{}
+	if err := json.NewDecoder(response.Body).Decode(&next); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Users) != 1 || next.NextCursor != "" {
+		t.Errorf("got %d users on the last page with cursor %q, want the last one", len(next.Users), next.NextCursor)
+	}
+}
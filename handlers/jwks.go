@@ -0,0 +1,11 @@
+package handlers
+
+import (
+	"server/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func JwksHandler(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(security.JWKS())
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"server/database"
 	"server/util"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -16,14 +17,25 @@ func LoginHandler(c *fiber.Ctx) error {
 	}
 
 	dbClient := c.Locals("dbClient").(*database.UsersClient)
-	res, err := database.Login(dbClient, creds)
+	res, err := database.Login(dbClient, util.RetrieveActor(c), creds)
 
 	// Check whether fields within err
 	// are not set to their zero values
 	if (fiber.Error{}) != err {
-		return c.Status(err.Code).JSON(fiber.Map{
+		body := fiber.Map{
 			"message": err.Message,
-		})
+		}
+
+		// Tell throttled clients when to try again
+		if res.RetryAfter > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(res.RetryAfter))
+			body["retryAfter"] = res.RetryAfter
+		}
+		if res.LockedUntil != nil {
+			body["lockedUntil"] = res.LockedUntil
+		}
+
+		return c.Status(err.Code).JSON(body)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(res)
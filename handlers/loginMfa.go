@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func LoginMfaHandler(c *fiber.Ctx) error {
+	// Read in the pending token and second factor
+	args, parsingError := util.RetrieveMfaLoginRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+	res, err := database.LoginWithMfa(dbClient, util.RetrieveActor(c), args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Message,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
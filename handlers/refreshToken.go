@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RefreshTokenHandler(c *fiber.Ctx) error {
+	args, parsingError := util.RetrieveRefreshTokenRequestData(c)
+	if parsingError != nil {
+		return util.HandleParsingError(c, parsingError)
+	}
+
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	res, err := database.RefreshToken(dbClient, args)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Message,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(res)
+}
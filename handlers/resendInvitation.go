@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ResendInvitationHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, retrievalError := util.RetrieveInvitationRequestData(c)
+	if retrievalError != nil {
+		return util.HandleParsingError(c, retrievalError)
+	}
+
+	user, err := database.ResendInvitation(dbClient, util.RetrieveActor(c), id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"server/database"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RevokeInvitationHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, retrievalError := util.RetrieveInvitationRequestData(c)
+	if retrievalError != nil {
+		return util.HandleParsingError(c, retrievalError)
+	}
+
+	err := database.RevokeInvitation(dbClient, util.RetrieveActor(c), id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"server/database"
+	"server/security"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RevokeSessionHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, sid, retrievalError := util.RetrieveSessionRequestData(c)
+	if retrievalError != nil {
+		return util.HandleParsingError(c, retrievalError)
+	}
+
+	// Users sign out of their own sessions, admins out of those of anyone
+	permitted, err := util.IsRequestFromSameUserOrPermitted(c, security.PERMISSION_USERS_UPDATE)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	if !permitted {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_USERS_UPDATE,
+		})
+	}
+
+	err = database.RevokeSession(dbClient, util.RetrieveActor(c), id, sid)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"server/database"
+	"server/security"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SignOutEverywhereHandler(c *fiber.Ctx) error {
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	id, retrievalError := util.RetrieveGetByIdRequestData(c)
+	if retrievalError != nil {
+		return util.HandleParsingError(c, retrievalError)
+	}
+
+	// Users sign themselves out everywhere, admins anyone
+	permitted, err := util.IsRequestFromSameUserOrPermitted(c, security.PERMISSION_USERS_UPDATE)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	if !permitted {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + security.PERMISSION_USERS_UPDATE,
+		})
+	}
+
+	err = database.SignOutEverywhere(dbClient, util.RetrieveActor(c), id)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
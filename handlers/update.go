@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"server/database"
+	"server/security"
 	"server/util"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,31 +12,26 @@ func UpdateHandler(c *fiber.Ctx) error {
 	// Access dbClient
 	dbClient := c.Locals("dbClient").(*database.UsersClient)
 
-	isAdmin, err := util.IsRequestFromAdmin(c)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": err.Error(),
-		})
-	}
-
 	id, updateData, retrievalError := util.RetrieveUpdateRequestData(c)
 	if retrievalError != nil {
 		return util.HandleParsingError(c, retrievalError)
 	}
 
-	if isAdmin {
-		user, err := database.UpdateByAdmin(dbClient, id, updateData)
-		if (fiber.Error{}) != err {
-			return c.Status(err.Code).JSON(fiber.Map{
-				"message": err.Error(),
-			})
-		}
-
-		return c.Status(fiber.StatusOK).JSON(user)
-	} else {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": database.ERROR_MESSAGE_ACCESS_RESTRICTED,
+	// Changing roles is reserved to role managers
+	canManageRoles, permissionError := util.HasPermission(c, security.PERMISSION_ROLES_MANAGE)
+	if permissionError != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": permissionError.Error(),
 		})
 	}
 
-}
+	user, err := database.UpdateByAdmin(dbClient, util.RetrieveActor(c), id, updateData, canManageRoles)
+	if (fiber.Error{}) != err {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"message": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(user)
+
+}
\ No newline at end of file
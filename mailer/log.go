@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"io"
+	"os"
+	"server/config"
+	"sync"
+)
+
+// LogMailer writes emails to a file, or to stdout, instead of sending
+// them. It is meant for local development.
+type LogMailer struct {
+	mutex sync.Mutex
+	from  string
+	file  string
+}
+
+func NewLogMailer(conf config.MailConfiguration) *LogMailer {
+	return &LogMailer{from: conf.From, file: conf.File}
+}
+
+func (mailer *LogMailer) Send(message Message) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	var out io.Writer = os.Stdout
+	if mailer.file != "" {
+		file, err := os.OpenFile(mailer.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	content := append(format(mailer.from, message), "\r\n\r\n"...)
+	_, err := out.Write(content)
+	return err
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"errors"
+	"server/config"
+)
+
+var ErrUnknownDriver = errors.New("unknown mail driver")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Default is the mailer configured in the mail section of the config
+var Default = mustNew(config.GetConfig().Mail)
+
+func New(conf config.MailConfiguration) (Mailer, error) {
+	switch conf.Driver {
+	case "smtp":
+		return NewSMTPMailer(conf), nil
+	case "log":
+		return NewLogMailer(conf), nil
+	}
+
+	return nil, ErrUnknownDriver
+}
+
+func mustNew(conf config.MailConfiguration) Mailer {
+	mailer, err := New(conf)
+	if err != nil {
+		panic(err)
+	}
+
+	return mailer
+}
+
+// Send sends message through the Default mailer
+func Send(message Message) error {
+	return Default.Send(message)
+}
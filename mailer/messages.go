@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+)
+
+func PasswordResetMessage(to string, name string, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"A password reset was requested for your account. Follow this link to choose a new password:\n\n"+
+			"%s\n\n"+
+			"The link can only be used once and expires in %s. "+
+			"If you did not ask for a new password, you can ignore this email.\n", name, link, ttl),
+	}
+}
+
+func InvitationMessage(to string, name string, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"An account was created for you. Follow this link to choose your password and start using it:\n\n"+
+			"%s\n\n"+
+			"The invitation expires in %s.\n", name, link, ttl),
+	}
+}
+
+func EmailVerificationMessage(to string, name string, link string, ttl time.Duration) Message {
+	return Message{
+		To:      to,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Follow this link to confirm that %s is your email address:\n\n"+
+			"%s\n\n"+
+			"The link can only be used once and expires in %s.\n", name, to, link, ttl),
+	}
+}
+
+// EmailChangeNoticeMessage warns the current address of
+// an account that it is being moved to newEmail
+func EmailChangeNoticeMessage(to string, name string, newEmail string) Message {
+	return Message{
+		To:      to,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"The email address of your account is being changed to %s. "+
+			"This address stays in use until the new one is confirmed.\n\n"+
+			"If you did not ask for this change, contact your administrator.\n", name, newEmail),
+	}
+}
+
+// PasswordExpiryMessage warns a user that their password
+// has to be changed before expiresAt
+func PasswordExpiryMessage(to string, name string, expiresAt time.Time) Message {
+	return Message{
+		To:      to,
+		Subject: "Your password expires soon",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Your password expires on %s. Change it before then to keep using your account without interruption.\n\n"+
+			"Once it has expired, you will have to change it the next time you log in.\n", name, expiresAt.Format("January 2, 2006")),
+	}
+}
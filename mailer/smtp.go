@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"server/config"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP relay, authenticating
+// with PLAIN auth when a username is configured
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(conf config.MailConfiguration) *SMTPMailer {
+	mailer := &SMTPMailer{
+		addr: fmt.Sprintf("%s:%d", conf.Smtp.Host, conf.Smtp.Port),
+		from: conf.From,
+	}
+
+	if conf.Smtp.Username != "" {
+		mailer.auth = smtp.PlainAuth("", conf.Smtp.Username, conf.Smtp.Password, conf.Smtp.Host)
+	}
+
+	return mailer
+}
+
+func (mailer *SMTPMailer) Send(message Message) error {
+	return smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{message.To}, format(mailer.from, message))
+}
+
+// format returns message as a RFC 5322 email
+func format(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + message.Subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	return []byte(builder.String())
+}
@@ -1,10 +1,37 @@
 package messages
 
 var (
-	ERROR_NAME_REQUIRED      = "name is required"
-	ERROR_EMAIL_REQUIRED     = "email is required"
-	ERROR_TITLE_REQUIRED     = "title is required"
-	ERROR_BIRTHDATE_REQUIRED = "birthdate is required"
-	ERROR_PASSWORD_REQUIRED  = "password is required"
-	ERROR_INVALID_EMAIL      = "Invalid email"
+	ERROR_NAME_REQUIRED             = "name is required"
+	ERROR_EMAIL_REQUIRED            = "email is required"
+	ERROR_TITLE_REQUIRED            = "title is required"
+	ERROR_BIRTHDATE_REQUIRED        = "birthdate is required"
+	ERROR_PASSWORD_REQUIRED         = "password is required"
+	ERROR_CURRENT_PASSWORD_REQUIRED = "currentPassword is required"
+	ERROR_INVALID_EMAIL             = "Invalid email"
+
+	ERROR_PASSWORD_LENGTH    = "password must have between %d and %d characters"
+	ERROR_PASSWORD_LOWERCASE = "password must contain at least one lowercase letter"
+	ERROR_PASSWORD_UPPERCASE = "password must contain at least one uppercase letter"
+	ERROR_PASSWORD_DIGIT     = "password must contain at least one digit"
+	ERROR_PASSWORD_SPECIAL   = "password must contain at least one special character"
+	ERROR_PASSWORD_TOO_WEAK  = "password is too easy to guess"
+	ERROR_PASSWORD_BREACHED  = "password has appeared in a data breach, choose another one"
+	ERROR_PASSWORD_UNCHECKED = "password cannot be checked against data breaches, try again later"
+
+	ERROR_REFRESH_TOKEN_REQUIRED = "refreshToken is required"
+	ERROR_RESET_TOKEN_REQUIRED   = "token is required"
+	ERROR_INVITATION_REQUIRED    = "token is required"
+	ERROR_EMAIL_TOKEN_REQUIRED   = "token is required"
+	ERROR_MFA_TOKEN_REQUIRED     = "mfaToken is required"
+	ERROR_MFA_CODE_REQUIRED      = "code or recoveryCode is required"
+	ERROR_INVALID_MFA_CODE       = "code must be 6 digits"
+
+	ERROR_ROLE_NAME_REQUIRED = "role names cannot be empty"
+	ERROR_UNKNOWN_PERMISSION = "unknown permission"
+
+	ERROR_INVALID_SORT        = "sort must be one of name, email, title, createdAt, updatedAt"
+	ERROR_INVALID_ORDER       = "order must be asc or desc"
+	ERROR_INVALID_IS_ADMIN    = "isAdmin must be true or false"
+	ERROR_INVALID_FILTER_DATE = "Invalid date. Format: YYYY-MM-DD or RFC 3339"
+	ERROR_QUERY_REQUIRED      = "q is required"
 )
@@ -3,21 +3,78 @@ package middleware
 import (
 	"github.com/gofiber/fiber/v2"
 	"net/http"
+	"server/database"
 	"server/security"
 	"server/util"
+)
 
-	jwtware "github.com/gofiber/jwt/v2"
+// Codes of the errors clients are expected to react to
+const (
+	ERROR_CODE_PASSWORD_CHANGE_REQUIRED = "PASSWORD_CHANGE_REQUIRED"
 )
 
-func RequireAuth(ctx *fiber.Ctx) error {
-	return jwtware.New(jwtware.Config{
-		SigningKey:    security.JwtSecretKey,
-		SigningMethod: security.JwtSigningMethod,
-		TokenLookup:   "header:Authorization",
-		ErrorHandler: func(c *fiber.Ctx, err error) error {
-			return c.
-				Status(http.StatusUnauthorized).
-				JSON(util.NewJError(err))
-		},
-	})(ctx)
-}
\ No newline at end of file
+// RequireAuth verifies the bearer token against the key its kid
+// header names, and rejects revoked or restricted tokens, as well
+// as the tokens of ended sessions
+func RequireAuth(c *fiber.Ctx) error {
+	return requireAuth(c, "")
+}
+
+// RequireAuthOrPasswordChange is RequireAuth for the change-password
+// endpoint, which also accepts the restricted tokens of users who
+// have to change their password
+func RequireAuthOrPasswordChange(c *fiber.Ctx) error {
+	return requireAuth(c, security.SCOPE_PASSWORD_CHANGE)
+}
+
+func requireAuth(c *fiber.Ctx, allowedScope string) error {
+	claims, err := security.ParseToken(util.ExtractToken(c))
+	if err != nil {
+		return c.
+			Status(http.StatusUnauthorized).
+			JSON(util.NewJError(err))
+	}
+
+	// Restricted tokens are only accepted by the
+	// endpoints they are meant for
+	if claims.Scope == security.SCOPE_PASSWORD_CHANGE && allowedScope != claims.Scope {
+		return c.
+			Status(http.StatusForbidden).
+			JSON(util.JError{Error: database.ERROR_MESSAGE_MUST_CHANGE_PASSWORD, Code: ERROR_CODE_PASSWORD_CHANGE_REQUIRED})
+	}
+
+	if claims.Scope != "" && claims.Scope != allowedScope {
+		return c.
+			Status(http.StatusUnauthorized).
+			JSON(util.JError{Error: database.ERROR_MESSAGE_RESTRICTED_TOKEN})
+	}
+
+	// Access dbClient
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	revoked, revocationError := database.IsTokenRevoked(dbClient, claims)
+	if (fiber.Error{}) != revocationError {
+		return c.Status(revocationError.Code).JSON(util.NewJError(&revocationError))
+	}
+
+	if revoked {
+		return c.
+			Status(http.StatusUnauthorized).
+			JSON(util.JError{Error: database.ERROR_MESSAGE_TOKEN_REVOKED})
+	}
+
+	// Signing out of a session ends its tokens right away
+	active, sessionError := database.IsSessionActive(dbClient, claims)
+	if (fiber.Error{}) != sessionError {
+		return c.Status(sessionError.Code).JSON(util.NewJError(&sessionError))
+	}
+
+	if !active {
+		return c.
+			Status(http.StatusUnauthorized).
+			JSON(util.JError{Error: database.ERROR_MESSAGE_SESSION_ENDED})
+	}
+
+	c.Locals("claims", claims)
+	return c.Next()
+}
@@ -6,9 +6,12 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// AddDatabaseClientToContext makes the client available to handlers,
+// and its user repository to those only needing that
 func AddDatabaseClientToContext(client *database.UsersClient) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		c.Locals("dbClient", client)
+		c.Locals("users", client.Users)
 		return c.Next()
 	}
 }
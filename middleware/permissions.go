@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"server/database"
+	"server/security"
+	"server/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequirePermission only lets requests through whose token grants
+// permission. It must come after RequireAuth.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*security.MyCustomClaims)
+		if !ok {
+			return c.
+				Status(http.StatusUnauthorized).
+				JSON(util.NewJError(security.ErrInvalidAuthToken))
+		}
+
+		if !claims.HasPermission(permission) {
+			return c.
+				Status(http.StatusForbidden).
+				JSON(util.JError{Error: database.ERROR_MESSAGE_PERMISSION_DENIED + ": " + permission})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireTargetWithinPermissions only lets requests through whose token
+// grants every permission of the user with the :id parameter, who they
+// act upon. It must come after RequireAuth.
+func RequireTargetWithinPermissions(c *fiber.Ctx) error {
+	dbClient := c.Locals("dbClient").(*database.UsersClient)
+
+	claims, ok := c.Locals("claims").(*security.MyCustomClaims)
+	if !ok {
+		return c.
+			Status(http.StatusUnauthorized).
+			JSON(util.NewJError(security.ErrInvalidAuthToken))
+	}
+
+	id, parsingError := util.ConvertStringIdIntoObjectId(c.Params("id"))
+	if parsingError != nil {
+		// Left to the handler to report
+		return c.Next()
+	}
+
+	if err := database.CheckPermissionsCover(dbClient, claims.Permissions, id); (fiber.Error{}) != err {
+		return c.
+			Status(err.Code).
+			JSON(util.JError{Error: err.Message})
+	}
+
+	return c.Next()
+}
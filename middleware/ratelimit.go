@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"server/database"
+	"server/ratelimit"
+	"server/security"
+	"server/util"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit limits requests with the first policy of limiter matching
+// them, and reports the state of their bucket in RateLimit-* headers.
+// Requests are let through if the backend fails.
+func RateLimit(limiter *ratelimit.Limiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		policy := limiter.Match(c.Method(), c.Path())
+		if policy == nil {
+			return c.Next()
+		}
+
+		result, err := limiter.Take(policy, rateLimitKey(c, limiter, policy))
+		if err != nil {
+			fmt.Println("Error rate limiting request:", err)
+			return c.Next()
+		}
+
+		c.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			return c.
+				Status(http.StatusTooManyRequests).
+				JSON(util.JError{Error: database.ERROR_MESSAGE_RATE_LIMITED})
+		}
+
+		return c.Next()
+	}
+}
+
+// rateLimitKey returns what the request is counted by under policy,
+// falling back to the IP when the request has no valid token or API key
+func rateLimitKey(c *fiber.Ctx, limiter *ratelimit.Limiter, policy *ratelimit.Policy) string {
+	switch policy.Key {
+	case ratelimit.KEY_USER:
+		if claims, err := security.ParseToken(util.ExtractToken(c)); err == nil && claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	case ratelimit.KEY_API_KEY:
+		// Made up keys would each get a bucket of their own
+		if apiKey := c.Get(limiter.ApiKeyHeader); apiKey != "" {
+			if hash, known := limiter.KnownApiKey(apiKey); known {
+				return "apiKey:" + hash
+			}
+		}
+	}
+
+	return "ip:" + util.ClientIP(c)
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Actor is whoever performs a mutation, as recorded in the audit log
+type Actor struct {
+	ID        string
+	IP        string
+	UserAgent string
+}
+
+// FieldChange is the value of a field before and after an update
+type FieldChange struct {
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+type AuditEvent struct {
+	ID        string                 `json:"id" bson:"_id,omitempty"`
+	ActorID   string                 `json:"actorId" bson:"actorId"`
+	Action    string                 `json:"action" bson:"action"`
+	TargetID  string                 `json:"targetId" bson:"targetId"`
+	Changes   map[string]FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	IP        string                 `json:"ip" bson:"ip"`
+	UserAgent string                 `json:"userAgent" bson:"userAgent"`
+	CreatedAt time.Time              `json:"createdAt" bson:"createdAt"`
+}
+
+// GetAuditArgs are the query parameters of GET /api/audit,
+// events being listed newest first
+type GetAuditArgs struct {
+	Limit    int    `query:"limit"`
+	Cursor   string `query:"cursor"`
+	ActorID  string `query:"actorId"`
+	Action   string `query:"action"`
+	TargetID string `query:"targetId"`
+	After    string `query:"after"`
+	Before   string `query:"before"`
+}
+
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+	Total      int64        `json:"total"`
+}
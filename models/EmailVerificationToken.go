@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// EmailVerificationToken is the server-side record of an emailed token
+// proving that its user owns Email, be it their current address or
+// the one they asked to change it to
+type EmailVerificationToken struct {
+	ID        string     `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string     `json:"userId" bson:"userId"`
+	Email     string     `json:"email" bson:"email"`
+	TokenHash string     `json:"-" bson:"tokenHash"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+type VerifyEmailArgs struct {
+	Token string
+}
+
+type RequestEmailVerificationArgs struct {
+	Email string
+}
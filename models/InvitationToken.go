@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// InvitationToken is the server-side record of the emailed
+// token an invited user accepts their invitation with
+type InvitationToken struct {
+	ID        string    `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string    `json:"userId" bson:"userId"`
+	TokenHash string    `json:"-" bson:"tokenHash"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// LockoutState tracks the recent failed logins of an account or an IP
+type LockoutState struct {
+	FailedLoginCount  int        `json:"failedLoginCount" bson:"failedLoginCount,omitempty"`
+	LastFailedLoginAt *time.Time `json:"lastFailedLoginAt,omitempty" bson:"lastFailedLoginAt,omitempty"`
+	LockedUntil       *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+}
+
+// LoginAttempts are the failed logins from an IP, whatever the account
+type LoginAttempts struct {
+	IP           string `json:"ip" bson:"_id"`
+	LockoutState `bson:",inline"`
+	ExpiresAt    time.Time `json:"expiresAt" bson:"expiresAt"`
+}
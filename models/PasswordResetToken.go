@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// PasswordResetToken is the server-side record of an emailed,
+// single-use password reset token
+type PasswordResetToken struct {
+	ID        string     `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string     `json:"userId" bson:"userId"`
+	TokenHash string     `json:"-" bson:"tokenHash"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+type ForgotPasswordArgs struct {
+	Email string
+}
+
+type ResetPasswordArgs struct {
+	Token    string
+	Password string
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is the server-side record of an opaque refresh token.
+// Tokens issued from the same login share a Family, so that reusing
+// an already rotated token can revoke every descendant at once.
+type RefreshToken struct {
+	ID        string     `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string     `json:"userId" bson:"userId"`
+	Family    string     `json:"family" bson:"family"`
+	TokenHash string     `json:"-" bson:"tokenHash"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty" bson:"usedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+}
+
+type RefreshTokenArgs struct {
+	RefreshToken string
+}
@@ -0,0 +1,11 @@
+package models
+
+// Role is a named set of permissions users can be given
+type Role struct {
+	Name        string   `json:"name" bson:"_id"`
+	Permissions []string `json:"permissions" bson:"permissions"`
+}
+
+type UpsertRoleArgs struct {
+	Permissions []string
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a login of a user on some device, which the access and
+// refresh tokens issued from that login belong to. Its ID is the family
+// of those refresh tokens. Sessions end when revoked, or when they have
+// not been refreshed for a refresh token lifetime.
+type Session struct {
+	ID         string     `json:"_id" bson:"_id"`
+	UserID     string     `json:"userId" bson:"userId"`
+	UserAgent  string     `json:"userAgent" bson:"userAgent"`
+	IP         string     `json:"ip" bson:"ip"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	LastSeenAt time.Time  `json:"lastSeenAt" bson:"lastSeenAt"`
+	ExpiresAt  time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+
+	// Set on the session of the request listing them
+	Current bool `json:"current" bson:"-"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// SigningKey is the stored form of a token signing key,
+// shared by every replica of the server
+type SigningKey struct {
+	Kid        string    `json:"kid" bson:"_id"`
+	Algorithm  string    `json:"alg" bson:"algorithm"`
+	PrivateKey string    `json:"-" bson:"privateKey"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt" bson:"expiresAt"`
+	// Kid of the key this one succeeds, empty for the first key. Only
+	// one key can succeed another, so that replicas rotating at the
+	// same time only create one key.
+	Replaces string `json:"-" bson:"replaces"`
+}
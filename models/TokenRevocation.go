@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// TokenRevocation marks access tokens as revoked before their expiry.
+// It either targets a single token through its Jti, or every token of
+// UserID issued up to RevokedAt. Either way it only needs to be kept
+// until ExpiresAt, once the tokens it covers have expired on their own.
+// The tokens of SparedSessionID, when set, survive a user-wide revocation.
+type TokenRevocation struct {
+	ID        string    `json:"_id,omitempty" bson:"_id,omitempty"`
+	UserID    string    `json:"userId" bson:"userId"`
+	Jti       string    `json:"jti,omitempty" bson:"jti,omitempty"`
+	RevokedAt time.Time `json:"revokedAt" bson:"revokedAt"`
+
+	SparedSessionID string `json:"sparedSessionId,omitempty" bson:"sparedSessionId,omitempty"`
+
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+// Statuses of users. Users created before statuses existed have none,
+// and count as active.
+const (
+	USER_STATUS_ACTIVE  = "active"
+	USER_STATUS_INVITED = "invited"
+)
+
 type User struct {
 	ID        string    `json:"_id,omitempty" bson:"_id,omitempty"`
 	Name      string    `json:"name,omitempty" bson:"name"`
@@ -11,9 +18,43 @@ type User struct {
 	Title     string    `json:"title,omitempty" bson:"title"`
 	Birthdate time.Time `json:"birthdate,omitempty" bson:"birthdate"`
 	Password  string    `json:"password,omitempty" bson:"password"`
-	IsAdmin   bool      `json:"isAdmin" bson:"isAdmin"`
+	Roles     []string  `json:"roles" bson:"roles"`
 	CreatedAt time.Time `json:"createdAt,omitempty" bson:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt,omitempty" bson:"updatedAt"`
+	// Set on soft deleted users, and stored as null on the others,
+	// which the unique index on email is restricted to
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt"`
+
+	EmailVerified   bool       `json:"emailVerified" bson:"emailVerified"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty" bson:"emailVerifiedAt,omitempty"`
+	// Address the user is moving to, Email staying in use until it is verified
+	PendingEmail string `json:"pendingEmail,omitempty" bson:"pendingEmail,omitempty"`
+
+	// Set on users who have to change their password before anything else
+	MustChangePassword bool `json:"mustChangePassword" bson:"mustChangePassword"`
+	// Users created before it existed have none, their password
+	// counting as set when they were created
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty" bson:"passwordChangedAt,omitempty"`
+	// Set once the user is warned that their password expires soon
+	PasswordExpiryReminderAt *time.Time `json:"-" bson:"passwordExpiryReminderAt,omitempty"`
+	// Hashes of the previous passwords, oldest first
+	PasswordHistory []string `json:"-" bson:"passwordHistory,omitempty"`
+
+	LockoutState `bson:",inline"`
+
+	Status string `json:"status,omitempty" bson:"status,omitempty"`
+	// Set on invited users, who have no password until they accept
+	InvitationExpiresAt *time.Time `json:"invitationExpiresAt,omitempty" bson:"invitationExpiresAt,omitempty"`
+
+	TwoFactorEnabled       bool     `json:"twoFactorEnabled" bson:"twoFactorEnabled"`
+	TwoFactorSecret        string   `json:"-" bson:"twoFactorSecret,omitempty"`
+	TwoFactorPendingSecret string   `json:"-" bson:"twoFactorPendingSecret,omitempty"`
+	TwoFactorLastStep      int64    `json:"-" bson:"twoFactorLastStep,omitempty"`
+	RecoveryCodes          []string `json:"-" bson:"recoveryCodes,omitempty"`
+
+	// Incremented by every update, which fails when the user
+	// was updated since it was read
+	Revision int64 `json:"-" bson:"revision,omitempty"`
 }
 
 type GetByTokenArgs struct {
@@ -25,28 +66,96 @@ type CreateByAdminArgs struct {
 	Email     string
 	Title     string
 	Birthdate string
-	IsAdmin   bool
+	Roles     []string
+}
+
+// CreatedUser is a user created by an admin, along with the
+// password they are given until they change it, only shown once
+type CreatedUser struct {
+	User
+	TemporaryPassword string `json:"temporaryPassword"`
 }
 
 type UpdateByAdminArgs struct {
 	CreateByAdminArgs
 }
 
+type AcceptInvitationArgs struct {
+	Token    string
+	Password string
+}
+
 type LoginArgs struct {
 	Email    string
 	Password string
 }
 
 type LoginResult struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	User         User   `json:"user"`
+
+	// Set instead of the tokens above when the user
+	// still has to provide their second factor
+	MfaRequired bool   `json:"mfaRequired,omitempty"`
+	MfaToken    string `json:"mfaToken,omitempty"`
+
+	// Set when the user has to change their password first. Token is then
+	// a restricted token only accepted by the change-password endpoint.
+	PasswordChangeRequired bool `json:"passwordChangeRequired,omitempty"`
+	// Set along PasswordChangeRequired when the password has expired
+	PasswordExpired bool `json:"passwordExpired,omitempty"`
+
+	// Set when the login is refused because of too many failed attempts:
+	// until when the account or IP is locked out, and in how many seconds
+	// the next attempt is allowed
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	RetryAfter  int        `json:"retryAfter,omitempty"`
 }
 
-type CreateDefaultAdminArgs struct {
-	Password string
+type SecondFactorArgs struct {
+	Code         string
+	RecoveryCode string
+}
+
+type MfaLoginArgs struct {
+	MfaToken string
+	SecondFactorArgs
+}
+
+type TwoFactorCodeArgs struct {
+	Code string
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type LogoutArgs struct {
+	RefreshToken string
+}
+
+// CreateWithPasswordArgs describe a user created along with their
+// password, by an operator who then passes the password on
+type CreateWithPasswordArgs struct {
+	Password           string
+	MustChangePassword bool
 	CreateByAdminArgs
 }
 
 type ChangePasswordArgs struct {
 	Password string
+	// Only required when users change their own password
+	CurrentPassword string
+}
+
+type UpdateProfileArgs struct {
+	Name      string
+	Title     string
+	Birthdate string
 }
@@ -0,0 +1,30 @@
+package models
+
+// GetAllArgs are the query parameters of GET /api/users/all.
+// Filters on text fields match case-insensitive substrings.
+type GetAllArgs struct {
+	Limit         int    `query:"limit"`
+	Cursor        string `query:"cursor"`
+	Name          string `query:"name"`
+	Email         string `query:"email"`
+	Title         string `query:"title"`
+	Role          string `query:"role"`
+	IsAdmin       string `query:"isAdmin"`
+	CreatedAfter  string `query:"createdAfter"`
+	CreatedBefore string `query:"createdBefore"`
+	Sort          string `query:"sort"`
+	Order         string `query:"order"`
+}
+
+// UserPage is one page of a user listing. NextCursor is
+// empty on the last page, and Total counts every match.
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	Total      int64  `json:"total"`
+}
+
+type SearchUsersArgs struct {
+	Q     string `query:"q"`
+	Limit int    `query:"limit"`
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"server/config"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrUnknownBackend = errors.New("unknown rate limit backend")
+
+// Result is the state of a bucket after a request tried to take a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the next token, when the request was refused
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets. Take removes a token from the bucket of key
+// if there is one, after refilling it for the time elapsed since.
+type Store interface {
+	Take(key string, policy Policy, now time.Time) (Result, error)
+}
+
+type Limiter struct {
+	policies     []Policy
+	store        Store
+	ApiKeyHeader string
+	apiKeyHashes map[string]bool
+}
+
+// New returns the limiter described by conf. The mongo backend keeps
+// its buckets in db, so that every replica enforces the same limits.
+func New(conf config.RateLimitConfiguration, db *mongo.Database) (*Limiter, error) {
+	limiter := &Limiter{ApiKeyHeader: conf.ApiKeyHeader, apiKeyHashes: map[string]bool{}}
+
+	for _, hash := range conf.ApiKeyHashes {
+		limiter.apiKeyHashes[strings.ToLower(hash)] = true
+	}
+
+	for _, policyConf := range conf.Policies {
+		policy, err := NewPolicy(policyConf)
+		if err != nil {
+			return nil, errors.New(err.Error() + ": " + policyConf.Name)
+		}
+		limiter.policies = append(limiter.policies, policy)
+	}
+
+	switch conf.Backend {
+	case "memory":
+		limiter.store = NewMemoryStore()
+	case "mongo":
+		store, err := NewMongoStore(db)
+		if err != nil {
+			return nil, err
+		}
+		limiter.store = store
+	default:
+		return nil, ErrUnknownBackend
+	}
+
+	return limiter, nil
+}
+
+// Match returns the first policy applying to requests with
+// the given method and path, or nil if none does
+func (limiter *Limiter) Match(method string, path string) *Policy {
+	for i := range limiter.policies {
+		if limiter.policies[i].Matches(method, path) {
+			return &limiter.policies[i]
+		}
+	}
+
+	return nil
+}
+
+// Take takes a token from the bucket of key under policy
+func (limiter *Limiter) Take(policy *Policy, key string) (Result, error) {
+	return limiter.store.Take(policy.Name+":"+key, *policy, time.Now())
+}
+
+// refill returns the tokens of a bucket holding tokens at updatedAt
+func refill(policy Policy, tokens float64, updatedAt time.Time, now time.Time) float64 {
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(policy.Burst), tokens+elapsed*policy.rate())
+}
+
+// newResult describes a bucket left with tokens
+func newResult(policy Policy, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     policy.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(policy.Burst) - tokens) / policy.rate()),
+	}
+
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / policy.rate())
+	}
+
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// KnownApiKey tells whether apiKey is one of the configured API keys,
+// and returns its hash, which stands for it since API keys are secrets
+func (limiter *Limiter) KnownApiKey(apiKey string) (string, bool) {
+	sum := sha256.Sum256([]byte(apiKey))
+	hash := hex.EncodeToString(sum[:])
+	return hash, limiter.apiKeyHashes[hash]
+}
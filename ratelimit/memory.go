@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// How often full buckets, which hold no information, are dropped
+var memorySweepInterval = time.Minute
+
+// MemoryStore keeps buckets in memory, each replica enforcing its own limits
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+func (store *MemoryStore) Take(key string, policy Policy, now time.Time) (Result, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sweep(now)
+
+	current, ok := store.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(policy.Burst), updatedAt: now}
+		store.buckets[key] = current
+	}
+
+	current.tokens = refill(policy, current.tokens, current.updatedAt, now)
+	current.updatedAt = now
+
+	allowed := current.tokens >= 1
+	if allowed {
+		current.tokens--
+	}
+
+	result := newResult(policy, current.tokens, allowed)
+	current.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+func (store *MemoryStore) sweep(now time.Time) {
+	if now.Sub(store.lastSweep) < memorySweepInterval {
+		return
+	}
+
+	for key, current := range store.buckets {
+		if !current.fullAt.After(now) {
+			delete(store.buckets, key)
+		}
+	}
+	store.lastSweep = now
+}
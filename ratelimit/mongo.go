@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var RATE_LIMITS_COLLECTION = "rate_limits"
+
+// MongoStore keeps buckets in MongoDB, shared by every replica.
+// Each bucket is refilled and taken from in a single atomic update.
+type MongoStore struct {
+	ctx        context.Context
+	collection *mongo.Collection
+}
+
+type mongoBucket struct {
+	Tokens  float64 `bson:"tokens"`
+	Allowed bool    `bson:"allowed"`
+}
+
+func NewMongoStore(db *mongo.Database) (*MongoStore, error) {
+	store := &MongoStore{ctx: context.Background(), collection: db.Collection(RATE_LIMITS_COLLECTION)}
+
+	// Buckets are dropped once full again
+	index := mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	if _, err := store.collection.Indexes().CreateOne(store.ctx, index); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (store *MongoStore) Take(key string, policy Policy, now time.Time) (Result, error) {
+	burst := float64(policy.Burst)
+	fullAfter := secondsToDuration(burst / policy.rate())
+
+	// Refill the bucket, new buckets starting full...
+	refilled := bson.D{{Key: "$min", Value: bson.A{
+		burst,
+		bson.D{{Key: "$add", Value: bson.A{
+			bson.D{{Key: "$ifNull", Value: bson.A{"$tokens", burst}}},
+			bson.D{{Key: "$multiply", Value: bson.A{
+				bson.D{{Key: "$divide", Value: bson.A{
+					bson.D{{Key: "$subtract", Value: bson.A{now, bson.D{{Key: "$ifNull", Value: bson.A{"$updatedAt", now}}}}}},
+					1000,
+				}}},
+				policy.rate(),
+			}}},
+		}}},
+	}}}
+
+	// ...then take a token if there is one
+	hasToken := bson.D{{Key: "$gte", Value: bson.A{"$tokens", 1}}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: refilled},
+			{Key: "updatedAt", Value: now},
+			{Key: "expiresAt", Value: now.Add(fullAfter)},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "allowed", Value: hasToken},
+			{Key: "tokens", Value: bson.D{{Key: "$cond", Value: bson.A{
+				hasToken,
+				bson.D{{Key: "$subtract", Value: bson.A{"$tokens", 1}}},
+				"$tokens",
+			}}}},
+		}}},
+	}
+
+	current := mongoBucket{}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := store.collection.FindOneAndUpdate(store.ctx, bson.D{{Key: "_id", Value: key}}, pipeline, opts).Decode(&current)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(policy, current.Tokens, current.Allowed), nil
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"errors"
+	"server/config"
+	"strings"
+	"time"
+)
+
+// What requests are counted by
+const (
+	KEY_IP      = "ip"
+	KEY_USER    = "user"
+	KEY_API_KEY = "apiKey"
+)
+
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Policy is a token bucket holding up to Burst tokens, refilled with Limit
+// tokens every Period. Every request it applies to takes a token from the
+// bucket of its key, and is refused when the bucket is empty.
+type Policy struct {
+	Name   string
+	Method string
+	Path   string
+	Key    string
+	Limit  int
+	Period time.Duration
+	Burst  int
+
+	segments []string
+}
+
+func NewPolicy(conf config.RateLimitPolicyConfiguration) (Policy, error) {
+	policy := Policy{
+		Name:   conf.Name,
+		Method: strings.ToUpper(conf.Method),
+		Path:   conf.Path,
+		Key:    conf.Key,
+		Limit:  conf.Limit,
+		Period: conf.Period,
+		Burst:  conf.Burst,
+	}
+
+	if policy.Name == "" || policy.Limit <= 0 || policy.Period <= 0 || policy.Burst < 0 {
+		return policy, ErrInvalidPolicy
+	}
+
+	switch policy.Key {
+	case KEY_IP, KEY_USER, KEY_API_KEY:
+	default:
+		return policy, ErrInvalidPolicy
+	}
+
+	if policy.Burst == 0 {
+		policy.Burst = policy.Limit
+	}
+
+	policy.segments = splitPath(policy.Path)
+	return policy, nil
+}
+
+// rate is the number of tokens added to the bucket per second
+func (policy Policy) rate() float64 {
+	return float64(policy.Limit) / policy.Period.Seconds()
+}
+
+// Matches reports whether the policy applies to requests with the given
+// method and path. An empty method matches every method. In the path of
+// the policy, ":name" matches any segment, and a final "*" any remainder.
+func (policy Policy) Matches(method string, path string) bool {
+	if policy.Method != "" && policy.Method != strings.ToUpper(method) {
+		return false
+	}
+
+	segments := splitPath(path)
+	for i, pattern := range policy.segments {
+		if pattern == "*" && i == len(policy.segments)-1 {
+			return true
+		}
+
+		if i >= len(segments) {
+			return false
+		}
+
+		if !strings.HasPrefix(pattern, ":") && pattern != segments[i] {
+			return false
+		}
+	}
+
+	return len(segments) == len(policy.segments)
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return []string{}
+	}
+
+	return strings.Split(trimmed, "/")
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"server/handlers"
+	"server/middleware"
+	"server/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func AuditRoute(route fiber.Router) {
+	route.Get("/", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_AUDIT_READ), handlers.GetAuditHandler)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"server/handlers"
+	"server/middleware"
+	"server/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func InvitationsRoute(route fiber.Router) {
+	route.Get("/", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_READ), handlers.GetInvitationsHandler)
+	route.Post("/", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_CREATE), handlers.CreateInvitationHandler)
+	route.Post("/accept", handlers.AcceptInvitationHandler)
+	route.Post("/:id/resend", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_CREATE), handlers.ResendInvitationHandler)
+	route.Delete("/:id", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_CREATE), handlers.RevokeInvitationHandler)
+}
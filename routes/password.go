@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"server/handlers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func PasswordRoute(route fiber.Router) {
+	route.Post("/forgot", handlers.ForgotPasswordHandler)
+	route.Post("/reset", handlers.ResetForgottenPasswordHandler)
+}
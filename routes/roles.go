@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"server/handlers"
+	"server/middleware"
+	"server/security"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RolesRoute(route fiber.Router) {
+	route.Get("/", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_ROLES_READ), handlers.GetRolesHandler)
+	route.Put("/:name", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_ROLES_MANAGE), handlers.UpsertRoleHandler)
+	route.Delete("/:name", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_ROLES_MANAGE), handlers.DeleteRoleHandler)
+}
@@ -3,18 +3,36 @@ package routes
 import (
 	"server/handlers"
 	"server/middleware"
+	"server/security"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 func UsersRoute(route fiber.Router) {
 	route.Get("/me", middleware.RequireAuth, handlers.GetByTokenHandler)
-	route.Get("/all", middleware.RequireAuth, handlers.GetAllHandler)
-	route.Get("/:id", middleware.RequireAuth, handlers.GetByIdHandler)
-	route.Delete("/:id", middleware.RequireAuth, handlers.DeleteHandler)
-	route.Put("/:id", middleware.RequireAuth, handlers.UpdateHandler)
-	route.Put("/password/reset/:id", middleware.RequireAuth, handlers.ResetPasswordHandler)
-	route.Put("/password/change/:id", middleware.RequireAuth, handlers.ChangePasswordHandler)
-	route.Post("/", middleware.RequireAuth, handlers.CreateHandler)
+	route.Put("/me", middleware.RequireAuth, handlers.UpdateProfileHandler)
+	route.Post("/me/2fa/enroll", middleware.RequireAuth, handlers.EnrollTwoFactorHandler)
+	route.Post("/me/2fa/confirm", middleware.RequireAuth, handlers.ConfirmTwoFactorHandler)
+	route.Post("/me/2fa/disable", middleware.RequireAuth, handlers.DisableTwoFactorHandler)
+	route.Post("/me/email/verification", middleware.RequireAuth, handlers.ResendEmailVerificationHandler)
+	route.Post("/email/verify", handlers.VerifyEmailHandler)
+	route.Post("/email/verification", handlers.RequestEmailVerificationHandler)
+	route.Get("/search", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_READ), handlers.SearchUsersHandler)
+	route.Get("/deleted", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_RESTORE), handlers.GetDeletedHandler)
+	route.Post("/:id/unlock", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_UPDATE), handlers.UnlockHandler)
+	route.Get("/:id/sessions", middleware.RequireAuth, handlers.GetSessionsHandler)
+	route.Delete("/:id/sessions", middleware.RequireAuth, handlers.SignOutEverywhereHandler)
+	route.Delete("/:id/sessions/:sid", middleware.RequireAuth, handlers.RevokeSessionHandler)
+	route.Post("/:id/restore", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_RESTORE), handlers.RestoreHandler)
+	route.Get("/all", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_READ), handlers.GetAllHandler)
+	route.Get("/:id", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_READ), handlers.GetByIdHandler)
+	route.Delete("/:id", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_DELETE), middleware.RequireTargetWithinPermissions, handlers.DeleteHandler)
+	route.Put("/:id", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_UPDATE), middleware.RequireTargetWithinPermissions, handlers.UpdateHandler)
+	route.Put("/password/reset/:id", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_PASSWORD_RESET), middleware.RequireTargetWithinPermissions, handlers.ResetPasswordHandler)
+	route.Put("/password/change/:id", middleware.RequireAuthOrPasswordChange, handlers.ChangePasswordHandler)
+	route.Post("/", middleware.RequireAuth, middleware.RequirePermission(security.PERMISSION_USERS_CREATE), handlers.CreateHandler)
 	route.Post("/login", handlers.LoginHandler)
+	route.Post("/login/mfa", handlers.LoginMfaHandler)
+	route.Post("/token/refresh", handlers.RefreshTokenHandler)
+	route.Post("/logout", middleware.RequireAuth, handlers.LogoutHandler)
 }
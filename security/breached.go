@@ -0,0 +1,76 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/config"
+	"strings"
+)
+
+// CheckBreachedPasswords makes sure the directory of breached password
+// hashes, when set, holds range files that can be read, so that a
+// missing or unreadable copy is noticed at startup
+func CheckBreachedPasswords() error {
+	dir := config.GetConfig().Password.Policy.BreachedPasswordsDir
+	if dir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && len(file.Name()) == 5 {
+			if _, err := hex.DecodeString(file.Name() + "0"); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("no breached password range files in " + dir)
+}
+
+// IsBreachedPassword looks password up in the local copy of breached
+// password hashes, a directory of range files as served by the Pwned
+// Passwords API: each file is named after the first 5 characters of
+// the SHA-1 hashes it holds, one "<SUFFIX>:<COUNT>" per line. Only the
+// file of the hash prefix is read. Without a directory set, no password
+// counts as breached.
+func IsBreachedPassword(password string) (bool, error) {
+	dir := config.GetConfig().Password.Policy.BreachedPasswordsDir
+	if dir == "" {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(dir, prefix))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineSuffix := strings.SplitN(line, ":", 2)[0]
+		if strings.EqualFold(lineSuffix, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
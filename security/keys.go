@@ -0,0 +1,219 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"server/config"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/form3tech-oss/jwt-go"
+)
+
+var (
+	ErrNoSigningKey         = errors.New("no signing key available")
+	ErrUnknownSigningKey    = errors.New("unknown signing key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// SigningKey is one of the asymmetric keys tokens are signed with,
+// identified in the token header by its Kid
+type SigningKey struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// JWK is the public part of a SigningKey, as served on the JWKS endpoint
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type keyRing struct {
+	sync.RWMutex
+	// newest first
+	sorted []SigningKey
+	keys   map[string]SigningKey
+}
+
+// SigningKeyPublishDelay is how long a new key is only used to verify
+// tokens, until every replica has loaded it and can verify its tokens
+var SigningKeyPublishDelay = config.GetConfig().Jwt.RefreshInterval
+
+var signingKeys = keyRing{keys: map[string]SigningKey{}}
+
+// GenerateSigningKey creates a new key for the given algorithm
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	key := SigningKey{Algorithm: algorithm, CreatedAt: time.Now()}
+
+	kid, err := newTokenId()
+	if err != nil {
+		return key, err
+	}
+	key.Kid = kid
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		key.PrivateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		key.PrivateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		err = ErrUnsupportedAlgorithm
+	}
+
+	return key, err
+}
+
+// EncodePrivateKey returns the PKCS #8 PEM encoding of a private key
+func EncodePrivateKey(key crypto.Signer) (string, error) {
+	bytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: bytes})), nil
+}
+
+func DecodePrivateKey(encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	return signer, nil
+}
+
+// SetSigningKeys replaces the keys tokens are verified with. The most
+// recently created one that is older than SigningKeyPublishDelay is
+// used to sign new tokens.
+func SetSigningKeys(keys []SigningKey) {
+	sorted := make([]SigningKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	byKid := make(map[string]SigningKey, len(sorted))
+	for _, key := range sorted {
+		byKid[key.Kid] = key
+	}
+
+	signingKeys.Lock()
+	defer signingKeys.Unlock()
+
+	signingKeys.keys = byKid
+	signingKeys.sorted = sorted
+}
+
+// currentSigningKey returns the key to sign new tokens with. When every
+// key is new, as on the first start, the oldest of them is used.
+func currentSigningKey() (SigningKey, error) {
+	signingKeys.RLock()
+	defer signingKeys.RUnlock()
+
+	if len(signingKeys.sorted) == 0 {
+		return SigningKey{}, ErrNoSigningKey
+	}
+
+	for _, key := range signingKeys.sorted {
+		if time.Since(key.CreatedAt) >= SigningKeyPublishDelay {
+			return key, nil
+		}
+	}
+
+	return signingKeys.sorted[len(signingKeys.sorted)-1], nil
+}
+
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	signingKeys.RLock()
+	key, ok := signingKeys.keys[kid]
+	signingKeys.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.PrivateKey.Public(), nil
+}
+
+// JWKS returns the public keys tokens can currently be verified with
+func JWKS() JWKSet {
+	signingKeys.RLock()
+	defer signingKeys.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(signingKeys.keys))}
+	for _, key := range signingKeys.keys {
+		jwk := JWK{Use: "sig", Kid: key.Kid, Alg: key.Algorithm}
+
+		switch public := key.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encodeBigInt(public.N, 0)
+			jwk.E = encodeBigInt(big.NewInt(int64(public.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = encodeBigInt(public.X, size)
+			jwk.Y = encodeBigInt(public.Y, size)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// encodeBigInt base64url-encodes n, left-padded with zeros to size bytes
+func encodeBigInt(n *big.Int, size int) string {
+	bytes := n.Bytes()
+	if len(bytes) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(bytes):], bytes)
+		bytes = padded
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
@@ -0,0 +1,292 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"server/config"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	ALGORITHM_ARGON2ID = "argon2id"
+	ALGORITHM_BCRYPT   = "bcrypt"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords with one algorithm and set of parameters
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify checks password against an encoded hash of this algorithm
+	Verify(password string, encoded string) (bool, error)
+	// Owns reports whether encoded was produced by this algorithm
+	Owns(encoded string) bool
+	// NeedsRehash reports whether encoded, produced by this
+	// algorithm, uses other parameters than the hasher
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	// CurrentHasher hashes new passwords, as set in the config
+	CurrentHasher = newPasswordHasher(config.GetConfig().Password.Hashing)
+
+	// Hashers of every supported algorithm, to verify existing hashes
+	passwordHashers = []PasswordHasher{
+		argon2idHasherFromConfig(config.GetConfig().Password.Hashing.Argon2id),
+		BcryptHasher{Cost: config.GetConfig().Password.Hashing.BcryptCost},
+	}
+
+	// hashingSlots bounds how many passwords are hashed at once
+	hashingSlots = make(chan struct{}, maxConcurrentHashing(config.GetConfig().Password.Hashing))
+)
+
+func maxConcurrentHashing(conf config.PasswordHashingConfiguration) int {
+	if conf.MaxConcurrent > 0 {
+		return conf.MaxConcurrent
+	}
+
+	return runtime.NumCPU()
+}
+
+// withHashingSlot runs hash once fewer than the maximum of
+// passwords are being hashed or verified
+func withHashingSlot(hash func()) {
+	hashingSlots <- struct{}{}
+	defer func() { <-hashingSlots }()
+
+	hash()
+}
+
+func newPasswordHasher(conf config.PasswordHashingConfiguration) PasswordHasher {
+	switch conf.Algorithm {
+	case ALGORITHM_ARGON2ID:
+		return argon2idHasherFromConfig(conf.Argon2id)
+	case ALGORITHM_BCRYPT:
+		return BcryptHasher{Cost: conf.BcryptCost}
+	}
+
+	panic(errors.New("unknown password hashing algorithm: " + conf.Algorithm))
+}
+
+// Characters of random passwords, one of each set at least,
+// so that they pass the strictest password policy
+var RANDOM_PASSWORD_CHARACTERS = []string{
+	"abcdefghijkmnopqrstuvwxyz",
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"23456789",
+	"!#%+-=?@_~",
+}
+
+var RANDOM_PASSWORD_LENGTH = 24
+
+// NewRandomPassword returns a password of RANDOM_PASSWORD_LENGTH
+// random characters, for users an operator creates
+func NewRandomPassword() (string, error) {
+	all := strings.Join(RANDOM_PASSWORD_CHARACTERS, "")
+
+	password := make([]byte, RANDOM_PASSWORD_LENGTH)
+	for i := range password {
+		characters := all
+		if i < len(RANDOM_PASSWORD_CHARACTERS) {
+			characters = RANDOM_PASSWORD_CHARACTERS[i]
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(characters))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = characters[n.Int64()]
+	}
+
+	// Move the characters of each set away from the start
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+func HashPassword(password string) (hash string, err error) {
+	withHashingSlot(func() {
+		hash, err = CurrentHasher.Hash(password)
+	})
+	return hash, err
+}
+
+// CheckPasswordHash verifies password against encoded,
+// whichever supported algorithm produced it
+func CheckPasswordHash(password string, encoded string) bool {
+	for _, hasher := range passwordHashers {
+		if hasher.Owns(encoded) {
+			var ok bool
+			var err error
+			withHashingSlot(func() {
+				ok, err = hasher.Verify(password, encoded)
+			})
+			return err == nil && ok
+		}
+	}
+
+	return false
+}
+
+// Hash of a password no user has, with the current algorithm and
+// parameters, made once it is first needed
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// CheckDummyPasswordHash verifies password against a hash no user has,
+// so that logins of unknown users take as long to refuse as wrong passwords
+func CheckDummyPasswordHash(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = HashPassword("dummy password of no user")
+	})
+
+	CheckPasswordHash(password, dummyPasswordHash)
+}
+
+// PasswordNeedsRehash reports whether encoded was not produced
+// by the current algorithm with the current parameters
+func PasswordNeedsRehash(encoded string) bool {
+	return !CurrentHasher.Owns(encoded) || CurrentHasher.NeedsRehash(encoded)
+}
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+type Argon2idHasher struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func argon2idHasherFromConfig(conf config.Argon2idConfiguration) Argon2idHasher {
+	return Argon2idHasher{
+		Memory:      conf.Memory,
+		Iterations:  conf.Iterations,
+		Parallelism: conf.Parallelism,
+		SaltLength:  conf.SaltLength,
+		KeyLength:   conf.KeyLength,
+	}
+}
+
+var phcEncoding = base64.RawStdEncoding
+
+func (hasher Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		ALGORITHM_ARGON2ID, argon2.Version,
+		hasher.Memory, hasher.Iterations, hasher.Parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (hasher Argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (hasher Argon2idHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+ALGORITHM_ARGON2ID+"$")
+}
+
+func (hasher Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != hasher.Memory ||
+		params.Iterations != hasher.Iterations ||
+		params.Parallelism != hasher.Parallelism ||
+		uint32(len(salt)) != hasher.SaltLength ||
+		uint32(len(key)) != hasher.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	params := Argon2idHasher{}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != ALGORITHM_ARGON2ID {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
+
+// BcryptHasher is kept to verify the hashes created before argon2id,
+// until they get rehashed. Bcrypt ignores anything past 72 bytes.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	return string(bytes), err
+}
+
+func (hasher BcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (hasher BcryptHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (hasher BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != hasher.Cost
+}
@@ -0,0 +1,79 @@
+package security
+
+// Permissions tokens can carry, granted to users through their roles
+const (
+	PERMISSION_USERS_READ            = "users:read"
+	PERMISSION_USERS_CREATE          = "users:create"
+	PERMISSION_USERS_UPDATE          = "users:update"
+	PERMISSION_USERS_DELETE          = "users:delete"
+	PERMISSION_USERS_RESTORE         = "users:restore"
+	PERMISSION_USERS_PASSWORD_RESET  = "users:password:reset"
+	PERMISSION_USERS_PASSWORD_CHANGE = "users:password:change"
+	PERMISSION_ROLES_READ            = "roles:read"
+	PERMISSION_ROLES_MANAGE          = "roles:manage"
+	PERMISSION_AUDIT_READ            = "audit:read"
+)
+
+const (
+	ROLE_ADMIN      = "admin"
+	ROLE_USER_ADMIN = "user-admin"
+	ROLE_SUPPORT    = "support"
+	ROLE_AUDITOR    = "auditor"
+)
+
+var Permissions = []string{
+	PERMISSION_USERS_READ,
+	PERMISSION_USERS_CREATE,
+	PERMISSION_USERS_UPDATE,
+	PERMISSION_USERS_DELETE,
+	PERMISSION_USERS_RESTORE,
+	PERMISSION_USERS_PASSWORD_RESET,
+	PERMISSION_USERS_PASSWORD_CHANGE,
+	PERMISSION_ROLES_READ,
+	PERMISSION_ROLES_MANAGE,
+	PERMISSION_AUDIT_READ,
+}
+
+// DefaultRoles are created when missing. Once created,
+// their permissions can be edited like any other role's.
+var DefaultRoles = map[string][]string{
+	ROLE_ADMIN: Permissions,
+	ROLE_USER_ADMIN: {
+		PERMISSION_USERS_READ,
+		PERMISSION_USERS_CREATE,
+		PERMISSION_USERS_UPDATE,
+		PERMISSION_USERS_DELETE,
+		PERMISSION_USERS_RESTORE,
+		PERMISSION_USERS_PASSWORD_RESET,
+		PERMISSION_USERS_PASSWORD_CHANGE,
+	},
+	ROLE_SUPPORT: {
+		PERMISSION_USERS_READ,
+		PERMISSION_USERS_PASSWORD_RESET,
+	},
+	ROLE_AUDITOR: {
+		PERMISSION_USERS_READ,
+		PERMISSION_ROLES_READ,
+		PERMISSION_AUDIT_READ,
+	},
+}
+
+func IsKnownPermission(permission string) bool {
+	for _, known := range Permissions {
+		if known == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (claims *MyCustomClaims) HasPermission(permission string) bool {
+	for _, granted := range claims.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package security
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Common passwords, most common first, the rank being the number of
+// guesses an attacker trying them in order needs
+var commonPasswords = []string{
+	"password", "123456", "qwerty", "letmein", "welcome", "admin", "iloveyou",
+	"monkey", "dragon", "football", "baseball", "sunshine", "princess",
+	"master", "shadow", "superman", "trustno", "passw", "login", "starwars",
+	"hello", "freedom", "whatever", "qazwsx", "michael", "charlie", "jordan",
+	"secret", "summer", "winter", "spring", "autumn", "flower", "hunter",
+	"soccer", "hockey", "killer", "batman", "access", "mustang",
+	"computer", "internet", "service", "default", "changeme", "user", "root",
+}
+
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+var leetSubstitutions = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i',
+}
+
+// Patterns shorter than this are guessed as random characters
+const minPatternLength = 3
+
+// PasswordStrength estimates how hard password is to guess, the way
+// zxcvbn does, from 0 (too guessable) to 4 (very unguessable).
+// Runs of common passwords, repeated characters, sequences, years
+// and keyboard rows only cost a few guesses, the other characters
+// cost as many as the character classes of the password allow.
+func PasswordStrength(password string) int {
+	guesses := passwordGuesses(password)
+
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	}
+
+	return 4
+}
+
+func passwordGuesses(password string) float64 {
+	lower := []rune(strings.ToLower(password))
+	unleet := make([]rune, len(lower))
+	for i, char := range lower {
+		if substitute, ok := leetSubstitutions[char]; ok {
+			unleet[i] = substitute
+		} else {
+			unleet[i] = char
+		}
+	}
+
+	cardinality := float64(bruteforceCardinality(password))
+	guesses := 1.0
+
+	for i := 0; i < len(lower); {
+		length, patternGuesses := matchPattern(lower[i:], unleet[i:], cardinality)
+		if length == 0 {
+			length, patternGuesses = 1, cardinality
+		}
+
+		guesses *= patternGuesses
+		i += length
+	}
+
+	return guesses
+}
+
+// matchPattern finds the longest pattern password starts with,
+// returning its length and how many guesses it takes
+func matchPattern(password []rune, unleet []rune, cardinality float64) (int, float64) {
+	length, guesses := 0, 0.0
+
+	consider := func(patternLength int, patternGuesses float64) {
+		if patternLength < minPatternLength {
+			return
+		}
+
+		if patternLength > length || (patternLength == length && patternGuesses < guesses) {
+			length, guesses = patternLength, patternGuesses
+		}
+	}
+
+	for rank, common := range commonPasswords {
+		if strings.HasPrefix(string(unleet), common) {
+			consider(len([]rune(common)), float64(rank+1))
+		}
+	}
+
+	repeat := 1
+	for repeat < len(password) && password[repeat] == password[0] {
+		repeat++
+	}
+	consider(repeat, cardinality*float64(repeat))
+
+	sequence := sequenceLength(password)
+	consider(sequence, 26*float64(sequence))
+
+	if isYear(password) {
+		consider(4, 120)
+	}
+
+	for _, row := range keyboardRows {
+		keyboard := keyboardLength(password, row)
+		consider(keyboard, 40*float64(keyboard))
+	}
+
+	return length, guesses
+}
+
+// sequenceLength is the length of the ascending or descending
+// sequence password starts with, like abcd or 4321
+func sequenceLength(password []rune) int {
+	if len(password) < 2 {
+		return len(password)
+	}
+
+	delta := password[1] - password[0]
+	if delta != 1 && delta != -1 {
+		return 1
+	}
+
+	length := 2
+	for length < len(password) && password[length]-password[length-1] == delta {
+		length++
+	}
+
+	return length
+}
+
+// isYear tells whether password starts with a year from 1900 to 2099
+func isYear(password []rune) bool {
+	if len(password) < 4 {
+		return false
+	}
+
+	for _, char := range password[:4] {
+		if !unicode.IsDigit(char) {
+			return false
+		}
+	}
+
+	century := string(password[:2])
+	return century == "19" || century == "20"
+}
+
+// keyboardLength is the length of the run of adjacent
+// keys of row, either way, password starts with
+func keyboardLength(password []rune, row string) int {
+	keys := []rune(row)
+	start := -1
+	for i, key := range keys {
+		if key == password[0] {
+			start = i
+		}
+	}
+
+	if start < 0 {
+		return 0
+	}
+
+	length := 0
+	for _, direction := range []int{1, -1} {
+		position, run := start, 1
+		for run < len(password) {
+			position += direction
+			if position < 0 || position >= len(keys) || keys[position] != password[run] {
+				break
+			}
+			run++
+		}
+
+		if run > length {
+			length = run
+		}
+	}
+
+	return length
+}
+
+// bruteforceCardinality is the size of the alphabet
+// the characters of password are taken from
+func bruteforceCardinality(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, char := range password {
+		switch {
+		case char >= 'a' && char <= 'z':
+			lower = true
+		case char >= 'A' && char <= 'Z':
+			upper = true
+		case char >= '0' && char <= '9':
+			digit = true
+		case char < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	cardinality := 0
+	if lower {
+		cardinality += 26
+	}
+	if upper {
+		cardinality += 26
+	}
+	if digit {
+		cardinality += 10
+	}
+	if symbol {
+		cardinality += 33
+	}
+	if other {
+		cardinality += 100
+	}
+
+	return cardinality
+}
@@ -1,8 +1,11 @@
 package security
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"server/models"
 	"time"
 	"server/config"
@@ -11,42 +14,126 @@ import (
 )
 
 var (
-	JwtSecretKey     = []byte(config.GetConfig().Token)
-	JwtSigningMethod = jwt.SigningMethodHS256.Name
+	JwtSigningMethod = config.GetConfig().Jwt.Algorithm
+	AccessTokenTTL   = config.GetConfig().Auth.AccessTokenTTL
+	RefreshTokenTTL  = config.GetConfig().Auth.RefreshTokenTTL
+	MfaTokenTTL      = config.GetConfig().Auth.MfaTokenTTL
+
+	PasswordChangeTokenTTL = config.GetConfig().Auth.PasswordChangeTokenTTL
+)
+
+// Scopes of restricted tokens, which only grant access to
+// the few endpoints that complete their user's login
+const (
+	SCOPE_MFA_PENDING     = "mfa"
+	SCOPE_PASSWORD_CHANGE = "password-change"
 )
 
 var ErrInvalidAuthToken   = errors.New("invalid auth-token")
 
+// MaxSignedTokenTTL is the longest lifetime of the tokens signed by
+// newSignedToken, after which every token signed so far has expired
+func MaxSignedTokenTTL() time.Duration {
+	max := AccessTokenTTL
+	for _, ttl := range []time.Duration{MfaTokenTTL, PasswordChangeTokenTTL} {
+		if ttl > max {
+			max = ttl
+		}
+	}
+
+	return max
+}
+
 type MyCustomClaims struct {
 	jwt.StandardClaims
-	IsAdmin bool `json:"isAdmin"`
+	Permissions []string `json:"permissions"`
+	Scope       string   `json:"scope,omitempty"`
+	// Session the token was issued for, restricted tokens having none
+	SessionID string `json:"sid,omitempty"`
+	// Issue time in Unix milliseconds, iat only counting seconds
+	IssuedAtMillis int64 `json:"iatms,omitempty"`
 }
 
-func NewToken(user *models.User) (string, error) {
+// NewToken returns an access token of session granting the given
+// permissions, which are those of the roles of user
+func NewToken(user *models.User, permissions []string, session string) (string, error) {
+	return newSignedToken(user, permissions, "", session, AccessTokenTTL)
+}
+
+// NewMfaToken returns a short-lived token proving that user got
+// their password right, to be exchanged for a session token
+// along with a second factor
+func NewMfaToken(user *models.User) (string, error) {
+	return newSignedToken(user, nil, SCOPE_MFA_PENDING, "", MfaTokenTTL)
+}
+
+// NewPasswordChangeToken returns a token only accepted by the
+// change-password endpoint, for users who must change their password
+func NewPasswordChangeToken(user *models.User) (string, error) {
+	return newSignedToken(user, nil, SCOPE_PASSWORD_CHANGE, "", PasswordChangeTokenTTL)
+}
+
+func newSignedToken(user *models.User, permissions []string, scope string, session string, ttl time.Duration) (string, error) {
+	// Every token gets its own id so that it can be revoked alone
+	jti, err := newTokenId()
+	if err != nil {
+		return "", err
+	}
+
 	// Create the Claims
+	now := time.Now()
 	claims := MyCustomClaims{
 		jwt.StandardClaims{
-			Id:        user.ID,
+			Id:        jti,
+			Subject:   user.ID,
 			Issuer:    user.ID,
-			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Add(time.Hour * 48).Unix(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
 		},
-		user.IsAdmin,
+		permissions,
+		scope,
+		session,
+		now.UnixNano() / int64(time.Millisecond),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtSecretKey)
+
+	key, err := currentSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.PrivateKey)
 }
 
-func validateSignedMethod(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+func newTokenId() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
 	}
-	return JwtSecretKey, nil
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// NewOpaqueToken returns an opaque, url-safe random token, used as refresh,
+// password reset, email verification or invitation token. Only its hash (see HashOpaqueToken) is ever stored.
+func NewOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func ParseToken(tokenString string) (*MyCustomClaims, error) {
 	claims := new(MyCustomClaims)
-	token, err := jwt.ParseWithClaims(tokenString, claims, validateSignedMethod)
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -7,15 +7,17 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type JError struct {
 	Error string `json:"error"`
+	// Machine readable reason of the error, when clients are
+	// expected to react to it
+	Code string `json:"code,omitempty"`
 }
 
 func NewJError(err error) JError {
-	jerr := JError{"generic error"}
+	jerr := JError{Error: "generic error"}
 	if err != nil {
 		jerr.Error = err.Error()
 	}
@@ -23,13 +25,19 @@ func NewJError(err error) JError {
 }
 
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return security.HashPassword(password)
 }
 
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	return security.CheckPasswordHash(password, hash)
+}
+
+func CheckDummyPasswordHash(password string) {
+	security.CheckDummyPasswordHash(password)
+}
+
+func PasswordNeedsRehash(hash string) bool {
+	return security.PasswordNeedsRehash(hash)
 }
 
 func ExtractToken(c *fiber.Ctx) string {
@@ -56,6 +64,42 @@ func RetrieveLoginRequestData(c *fiber.Ctx) (models.LoginArgs, error) {
 	return creds, err
 }
 
+func RetrieveRefreshTokenRequestData(c *fiber.Ctx) (models.RefreshTokenArgs, error) {
+	args := models.RefreshTokenArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveMfaLoginRequestData(c *fiber.Ctx) (models.MfaLoginArgs, error) {
+	args := models.MfaLoginArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveTwoFactorCodeRequestData(c *fiber.Ctx) (models.TwoFactorCodeArgs, error) {
+	args := models.TwoFactorCodeArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveSecondFactorRequestData(c *fiber.Ctx) (models.SecondFactorArgs, error) {
+	args := models.SecondFactorArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveGetAllRequestData(c *fiber.Ctx) (models.GetAllArgs, error) {
+	args := models.GetAllArgs{}
+	err := c.QueryParser(&args)
+	return args, err
+}
+
+func RetrieveSearchUsersRequestData(c *fiber.Ctx) (models.SearchUsersArgs, error) {
+	args := models.SearchUsersArgs{}
+	err := c.QueryParser(&args)
+	return args, err
+}
+
 func RetrieveCreateRequestData(c *fiber.Ctx) (models.CreateByAdminArgs, error) {
 	data := models.CreateByAdminArgs{}
 	err := c.BodyParser(&data)
@@ -88,17 +132,94 @@ func RetrieveGetByIdRequestData(c *fiber.Ctx) (primitive.ObjectID, error) {
 	return RetrieveDeleteRequestData(c)
 }
 
+func RetrieveRestoreRequestData(c *fiber.Ctx) (primitive.ObjectID, error) {
+	return RetrieveGetByIdRequestData(c)
+}
+
+func RetrieveForgotPasswordRequestData(c *fiber.Ctx) (models.ForgotPasswordArgs, error) {
+	args := models.ForgotPasswordArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveResetForgottenPasswordRequestData(c *fiber.Ctx) (models.ResetPasswordArgs, error) {
+	args := models.ResetPasswordArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveAcceptInvitationRequestData(c *fiber.Ctx) (models.AcceptInvitationArgs, error) {
+	args := models.AcceptInvitationArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveInvitationRequestData(c *fiber.Ctx) (primitive.ObjectID, error) {
+	return RetrieveGetByIdRequestData(c)
+}
+
+func RetrieveVerifyEmailRequestData(c *fiber.Ctx) (models.VerifyEmailArgs, error) {
+	args := models.VerifyEmailArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveRequestEmailVerificationRequestData(c *fiber.Ctx) (models.RequestEmailVerificationArgs, error) {
+	args := models.RequestEmailVerificationArgs{}
+	err := c.BodyParser(&args)
+	return args, err
+}
+
+func RetrieveUnlockRequestData(c *fiber.Ctx) (primitive.ObjectID, error) {
+	return RetrieveGetByIdRequestData(c)
+}
+
 func RetrieveResetPasswordRequestData(c *fiber.Ctx) (primitive.ObjectID, error) {
 	return RetrieveGetByIdRequestData(c)
 }
 
+func RetrieveSessionRequestData(c *fiber.Ctx) (primitive.ObjectID, string, error) {
+	id, err := RetrieveGetByIdRequestData(c)
+	return id, c.Params("sid"), err
+}
+
+// IsRequestFromSameUserOrPermitted tells whether the request is about
+// the user making it, or made by a user granted permission
+func IsRequestFromSameUserOrPermitted(c *fiber.Ctx, permission string) (bool, fiber.Error) {
+	isSameUser, err := IsRequestFromSameUser(c)
+	if (fiber.Error{}) != err || isSameUser {
+		return isSameUser, err
+	}
+
+	permitted, permissionError := HasPermission(c, permission)
+	if permissionError != nil {
+		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: permissionError.Error()}
+	}
+
+	return permitted, fiber.Error{}
+}
+
 func IsRequestFromSameUser(c *fiber.Ctx) (bool, fiber.Error) {
 	claims, err := security.ParseToken(ExtractToken(c))
 	if err != nil {
 		return false, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 
-	return claims.Id == c.Params("id"), fiber.Error{}
+	return claims.Subject == c.Params("id"), fiber.Error{}
+}
+
+func RetrieveUpdateProfileRequestData(c *fiber.Ctx) (primitive.ObjectID, models.UpdateProfileArgs, fiber.Error) {
+	args := models.UpdateProfileArgs{}
+	id, err := RetrieveIdFromToken(c)
+	if (fiber.Error{}) != err {
+		return id, args, err
+	}
+
+	if parsingError := c.BodyParser(&args); parsingError != nil {
+		return id, args, fiber.Error{Code: fiber.StatusBadRequest, Message: parsingError.Error()}
+	}
+
+	return id, args, fiber.Error{}
 }
 
 func RetrieveChangePasswordRequestData(c *fiber.Ctx) (primitive.ObjectID, models.ChangePasswordArgs, fiber.Error) {
@@ -110,13 +231,13 @@ func RetrieveChangePasswordRequestData(c *fiber.Ctx) (primitive.ObjectID, models
 
 	err2 := c.BodyParser(&args)
 	if err2 != nil {
-		return id, args, fiber.Error{Code: fiber.StatusBadRequest, Message: err.Error()}
+		return id, args, fiber.Error{Code: fiber.StatusBadRequest, Message: err2.Error()}
 	}
 
 	return id, args, fiber.Error{}
 }
 
-func IsRequestFromAdmin(c *fiber.Ctx) (bool, error) {
+func HasPermission(c *fiber.Ctx, permission string) (bool, error) {
 	token := ExtractToken(c)
 
 	claims, err := security.ParseToken(token)
@@ -124,7 +245,7 @@ func IsRequestFromAdmin(c *fiber.Ctx) (bool, error) {
 		return false, err
 	}
 
-	return claims.IsAdmin, nil
+	return claims.HasPermission(permission), nil
 }
 
 func RetrieveIdFromToken(c *fiber.Ctx) (primitive.ObjectID, fiber.Error) {
@@ -133,7 +254,7 @@ func RetrieveIdFromToken(c *fiber.Ctx) (primitive.ObjectID, fiber.Error) {
 		return primitive.ObjectID{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 
-	id, err := ConvertStringIdIntoObjectId(claims.Id)
+	id, err := ConvertStringIdIntoObjectId(claims.Subject)
 	if err != nil {
 		return primitive.ObjectID{}, fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
@@ -141,6 +262,70 @@ func RetrieveIdFromToken(c *fiber.Ctx) (primitive.ObjectID, fiber.Error) {
 	return id, fiber.Error{}
 }
 
+func RetrieveClaimsFromToken(c *fiber.Ctx) (*security.MyCustomClaims, fiber.Error) {
+	claims, err := security.ParseToken(ExtractToken(c))
+	if err != nil {
+		return nil, fiber.Error{Code: fiber.StatusUnauthorized, Message: err.Error()}
+	}
+
+	return claims, fiber.Error{}
+}
+
+// ClientIP returns the IP of the client of the request. When its
+// trusted proxy lists several, the client can have set all but the
+// last one, which is the one the proxy appended.
+func ClientIP(c *fiber.Ctx) string {
+	ip := c.IP()
+	if i := strings.LastIndex(ip, ","); i >= 0 {
+		ip = ip[i+1:]
+	}
+
+	return strings.TrimSpace(ip)
+}
+
+// RetrieveActor identifies who performs the request, for the audit log.
+// It expects the claims to have been set by the RequireAuth middleware.
+func RetrieveActor(c *fiber.Ctx) models.Actor {
+	actor := models.Actor{
+		IP:        ClientIP(c),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+
+	if claims, ok := c.Locals("claims").(*security.MyCustomClaims); ok {
+		actor.ID = claims.Subject
+	}
+
+	return actor
+}
+
+func RetrieveGetAuditRequestData(c *fiber.Ctx) (models.GetAuditArgs, error) {
+	args := models.GetAuditArgs{}
+	err := c.QueryParser(&args)
+	return args, err
+}
+
+func RetrieveRoleName(c *fiber.Ctx) string {
+	return c.Params("name")
+}
+
+func RetrieveUpsertRoleRequestData(c *fiber.Ctx) (string, models.UpsertRoleArgs, error) {
+	args := models.UpsertRoleArgs{}
+	err := c.BodyParser(&args)
+	return RetrieveRoleName(c), args, err
+}
+
+func RetrieveLogoutRequestData(c *fiber.Ctx) (models.LogoutArgs, error) {
+	args := models.LogoutArgs{}
+
+	// The refresh token is optional
+	if len(c.Body()) == 0 {
+		return args, nil
+	}
+
+	err := c.BodyParser(&args)
+	return args, err
+}
+
 func HandleParsingError(c *fiber.Ctx, parsingError error) error{
 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 		"message": parsingError.Error(),
@@ -1,6 +1,7 @@
 package validators
 
 import (
+	"server/messages"
 	"server/models"
 
 	validation "github.com/go-ozzo/ozzo-validation"
@@ -27,8 +28,8 @@ func ValidateCreateByAdminArgs(args models.CreateByAdminArgs) error {
 		validation.Field(&args.Title, titleValidationRules...),
 		// Birthdate cannot be empty, and must be a date string of the format "YYYY-MM-DD"
 		validation.Field(&args.Birthdate, birthdateValidationRules...),
-		// IsAdmin cannot be empty
-		validation.Field(&args.IsAdmin),
+		// Roles must not contain empty names
+		validation.Field(&args.Roles, rolesValidationRules...),
 	)
 
 	return ParseValidationError(err)
@@ -44,8 +45,8 @@ func ValidateUpdateByAdminArgs(args models.UpdateByAdminArgs) error {
 		validation.Field(&args.Title, titleValidationRules...),
 		// Birthdate cannot be empty, and must be a date string of the format "YYYY-MM-DD"
 		validation.Field(&args.Birthdate, birthdateValidationRules...),
-		// IsAdmin cannot be empty
-		validation.Field(&args.IsAdmin),
+		// Roles must not contain empty names
+		validation.Field(&args.Roles, rolesValidationRules...),
 	)
 
 	return ParseValidationError(err)
@@ -60,7 +61,135 @@ func ValidateChangePasswordArgs(args models.ChangePasswordArgs) error {
 	return ParseValidationError(err)
 }
 
-func ValidateCreateDefaultAdminArgs(args models.CreateDefaultAdminArgs) error {
+func ValidateForgotPasswordArgs(args models.ForgotPasswordArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Email cannot be empty, and must be a valid email
+		validation.Field(&args.Email, emailValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateResetPasswordArgs(args models.ResetPasswordArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Token cannot be empty
+		validation.Field(&args.Token, validation.Required.Error(messages.ERROR_RESET_TOKEN_REQUIRED)),
+		// Password must be strong enough
+		validation.Field(&args.Password, passwordValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateAcceptInvitationArgs(args models.AcceptInvitationArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Token cannot be empty
+		validation.Field(&args.Token, validation.Required.Error(messages.ERROR_INVITATION_REQUIRED)),
+		// Password must be strong enough
+		validation.Field(&args.Password, passwordValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateRequestEmailVerificationArgs(args models.RequestEmailVerificationArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Email cannot be empty, and must be a valid email
+		validation.Field(&args.Email, emailValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateVerifyEmailArgs(args models.VerifyEmailArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Token cannot be empty
+		validation.Field(&args.Token, validation.Required.Error(messages.ERROR_EMAIL_TOKEN_REQUIRED)),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateUpsertRoleArgs(args models.UpsertRoleArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Permissions must all be known permissions
+		validation.Field(&args.Permissions, validation.Each(validation.By(knownPermission))),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateGetAllArgs(args models.GetAllArgs, sortableFields []string, maxLimit int) error {
+	sortable := make([]interface{}, len(sortableFields))
+	for i, field := range sortableFields {
+		sortable[i] = field
+	}
+
+	err := validation.ValidateStruct(&args,
+		// Limit must be between 0 (the default) and maxLimit
+		validation.Field(&args.Limit, validation.Min(0), validation.Max(maxLimit)),
+		// Sort must be a sortable field
+		validation.Field(&args.Sort, validation.In(sortable...).Error(messages.ERROR_INVALID_SORT)),
+		// Order is either asc or desc
+		validation.Field(&args.Order, validation.In("asc", "desc").Error(messages.ERROR_INVALID_ORDER)),
+		// IsAdmin is a boolean
+		validation.Field(&args.IsAdmin, validation.In("true", "false").Error(messages.ERROR_INVALID_IS_ADMIN)),
+		// Dates are RFC 3339 timestamps or "YYYY-MM-DD" dates
+		validation.Field(&args.CreatedAfter, filterDateValidationRules...),
+		validation.Field(&args.CreatedBefore, filterDateValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateGetAuditArgs(args models.GetAuditArgs, maxLimit int) error {
+	err := validation.ValidateStruct(&args,
+		// Limit must be between 0 (the default) and maxLimit
+		validation.Field(&args.Limit, validation.Min(0), validation.Max(maxLimit)),
+		// Dates are RFC 3339 timestamps or "YYYY-MM-DD" dates
+		validation.Field(&args.After, filterDateValidationRules...),
+		validation.Field(&args.Before, filterDateValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateSearchUsersArgs(args models.SearchUsersArgs, maxLimit int) error {
+	err := validation.ValidateStruct(&args,
+		// Q cannot be empty
+		validation.Field(&args.Q, validation.Required.Error(messages.ERROR_QUERY_REQUIRED)),
+		// Limit must be between 0 (the default) and maxLimit
+		validation.Field(&args.Limit, validation.Min(0), validation.Max(maxLimit)),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateChangeOwnPasswordArgs(args models.ChangePasswordArgs) error {
+	err := validation.ValidateStruct(&args,
+		// CurrentPassword cannot be empty
+		validation.Field(&args.CurrentPassword, validation.Required.Error(messages.ERROR_CURRENT_PASSWORD_REQUIRED)),
+		// Password cannot be empty
+		validation.Field(&args.Password, passwordValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateUpdateProfileArgs(args models.UpdateProfileArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Name cannot be empty
+		validation.Field(&args.Name, nameValidationRules...),
+		// Title cannot be empty
+		validation.Field(&args.Title, titleValidationRules...),
+		// Birthdate cannot be empty, and must be a date string of the format "YYYY-MM-DD"
+		validation.Field(&args.Birthdate, birthdateValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateCreateWithPasswordArgs(args models.CreateWithPasswordArgs) error {
 	err := validation.ValidateStruct(&args,
 		// Name cannot be empty
 		validation.Field(&args.Name, nameValidationRules...),
@@ -70,11 +199,55 @@ func ValidateCreateDefaultAdminArgs(args models.CreateDefaultAdminArgs) error {
 		validation.Field(&args.Title, titleValidationRules...),
 		// Birthdate cannot be empty, and must be a date string of the format "YYYY-MM-DD"
 		validation.Field(&args.Birthdate, birthdateValidationRules...),
-		// IsAdmin must be set to false
-		validation.Field(&args.IsAdmin),
-		// Password cannot be empty
+		// Roles must not contain empty names
+		validation.Field(&args.Roles, rolesValidationRules...),
+		// Password must follow the password policy
 		validation.Field(&args.Password, passwordValidationRules...),
 	)
 
 	return ParseValidationError(err)
 }
+
+func ValidateRefreshTokenArgs(args models.RefreshTokenArgs) error {
+	err := validation.ValidateStruct(&args,
+		// RefreshToken cannot be empty
+		validation.Field(&args.RefreshToken, validation.Required.Error(messages.ERROR_REFRESH_TOKEN_REQUIRED)),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateMfaLoginArgs(args models.MfaLoginArgs) error {
+	err := validation.ValidateStruct(&args,
+		// MfaToken cannot be empty
+		validation.Field(&args.MfaToken, validation.Required.Error(messages.ERROR_MFA_TOKEN_REQUIRED)),
+	)
+	if err != nil {
+		return ParseValidationError(err)
+	}
+
+	return ValidateSecondFactorArgs(args.SecondFactorArgs)
+}
+
+func ValidateSecondFactorArgs(args models.SecondFactorArgs) error {
+	// A recovery code can be given instead of a TOTP code
+	if args.RecoveryCode != "" {
+		return nil
+	}
+
+	err := validation.ValidateStruct(&args,
+		// Code cannot be empty, and must be 6 digits
+		validation.Field(&args.Code, mfaCodeValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
+
+func ValidateTwoFactorCodeArgs(args models.TwoFactorCodeArgs) error {
+	err := validation.ValidateStruct(&args,
+		// Code cannot be empty, and must be 6 digits
+		validation.Field(&args.Code, mfaCodeValidationRules...),
+	)
+
+	return ParseValidationError(err)
+}
@@ -2,9 +2,14 @@ package validators
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"regexp"
+	"server/config"
 	"server/messages"
+	"server/security"
 	"strings"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
@@ -28,17 +33,95 @@ var birthdateValidationRules = []validation.Rule{
 	validation.Date("2006-01-02").Error("Invalid date for birthdate. Format: YYYY-MM-DD"),
 }
 
-var isAdminValidationRules = []validation.Rule{
-	validation.In(false).Error("isAdmin can only be set to false"),
+var rolesValidationRules = []validation.Rule{
+	validation.Each(validation.Required.Error(messages.ERROR_ROLE_NAME_REQUIRED)),
 }
 
-var passwordValidationRules = []validation.Rule{
-	validation.Required.Error(messages.ERROR_PASSWORD_REQUIRED),
-	validation.Match(regexp.MustCompile("[0-9]")).Error("password must contain at least one digit"),
-	validation.Match(regexp.MustCompile("[a-z]")).Error("password must contain at least one lowercase letter"),
-	validation.Match(regexp.MustCompile("[A-Z]")).Error("password must contain at least one uppercase letter"),
-	validation.Match(regexp.MustCompile("[#?!@$%^&*-]")).Error("password must contain at least one special character"),
-	validation.Match(regexp.MustCompile("[a-zA-Z0-9#?!@$%^&*-]{8,}$")).Error("password must have at least 8 characters"),
+func knownPermission(value interface{}) error {
+	permission, _ := value.(string)
+	if !security.IsKnownPermission(permission) {
+		return errors.New(messages.ERROR_UNKNOWN_PERMISSION + ": " + permission)
+	}
+
+	return nil
+}
+
+var filterDateValidationRules = []validation.Rule{
+	validation.By(func(value interface{}) error {
+		date, _ := value.(string)
+		if date == "" {
+			return nil
+		}
+
+		if _, err := time.Parse(time.RFC3339, date); err == nil {
+			return nil
+		}
+
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			return nil
+		}
+
+		return errors.New(messages.ERROR_INVALID_FILTER_DATE)
+	}),
+}
+
+var mfaCodeValidationRules = []validation.Rule{
+	validation.Required.Error(messages.ERROR_MFA_CODE_REQUIRED),
+	validation.Match(regexp.MustCompile("^[0-9]{6}$")).Error(messages.ERROR_INVALID_MFA_CODE),
+}
+
+// passwordValidationRules follow the password policy of the config,
+// Required coming first for the checks of existing passwords
+var passwordValidationRules = newPasswordValidationRules(config.GetConfig().Password.Policy)
+
+func newPasswordValidationRules(policy config.PasswordPolicyConfiguration) []validation.Rule {
+	rules := []validation.Rule{
+		validation.Required.Error(messages.ERROR_PASSWORD_REQUIRED),
+		validation.RuneLength(policy.MinLength, policy.MaxLength).Error(fmt.Sprintf(messages.ERROR_PASSWORD_LENGTH, policy.MinLength, policy.MaxLength)),
+	}
+
+	if policy.RequireLowercase {
+		rules = append(rules, validation.Match(regexp.MustCompile(`\p{Ll}`)).Error(messages.ERROR_PASSWORD_LOWERCASE))
+	}
+	if policy.RequireUppercase {
+		rules = append(rules, validation.Match(regexp.MustCompile(`\p{Lu}`)).Error(messages.ERROR_PASSWORD_UPPERCASE))
+	}
+	if policy.RequireDigit {
+		rules = append(rules, validation.Match(regexp.MustCompile(`\p{Nd}`)).Error(messages.ERROR_PASSWORD_DIGIT))
+	}
+	if policy.RequireSpecial {
+		rules = append(rules, validation.Match(regexp.MustCompile(`[^\p{L}\p{N}]`)).Error(messages.ERROR_PASSWORD_SPECIAL))
+	}
+
+	return append(rules,
+		validation.By(func(value interface{}) error {
+			password, _ := value.(string)
+			if security.PasswordStrength(password) < policy.MinStrength {
+				return errors.New(messages.ERROR_PASSWORD_TOO_WEAK)
+			}
+
+			return nil
+		}),
+		validation.By(notBreachedPassword),
+	)
+}
+
+// notBreachedPassword refuses passwords when the breached
+// password list cannot be read, logging why
+func notBreachedPassword(value interface{}) error {
+	password, _ := value.(string)
+
+	breached, err := security.IsBreachedPassword(password)
+	if err != nil {
+		log.Println("Error checking breached passwords:", err)
+		return errors.New(messages.ERROR_PASSWORD_UNCHECKED)
+	}
+
+	if breached {
+		return errors.New(messages.ERROR_PASSWORD_BREACHED)
+	}
+
+	return nil
 }
 
 func ParseValidationError(err error) error {